@@ -5,12 +5,23 @@
 package chicklet
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"runtime"
 )
 
+// Panic raises the run-time error err as a panic of the script, so
+// that deferred calls run and may recover it.  The recovered value is
+// err itself.
+func (t *Thread) Panic(err error) {
+	panic(&PanicError{interfaceFromNative(t, err)})
+}
+
 // Abort aborts the thread's current computation,
-// causing the innermost Try to return err.
+// causing the innermost Try to return err.  It is used for errors
+// that scripts must not recover, such as exceeded limits; run-time
+// errors are raised with Panic.
 func (t *Thread) Abort(err error) {
 	if t.abort == nil {
 		panic("abort: " + err.Error())
@@ -21,22 +32,151 @@ func (t *Thread) Abort(err error) {
 
 // Try executes a computation; if the computation
 // Aborts, Try returns the error passed to abort.
+//
+// If t's run is cancelled, Try returns the context's error without
+// waiting for the computation, which aborts at its next chance.
 func (t *Thread) Try(f func(t *Thread)) error {
 	oc := t.abort
-	c := make(chan error)
+	// The computation can always deliver its result, so that its
+	// goroutine finishes even if nobody waits for it.
+	c := make(chan error, 1)
 	t.abort = c
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				c <- &CallError{fmt.Sprint(r)}
+				switch r := r.(type) {
+				case *PanicError:
+					c <- r
+				default:
+					if err := t.limitError(r); err != nil {
+						// A callback into the script
+						// ran out of limits.
+						c <- err
+					} else {
+						c <- &CallError{fmt.Sprint(r)}
+					}
+				}
 			}
 		}()
 		f(t)
 		c <- nil
 	}()
-	err := <-c
-	t.abort = oc
-	return err
+	select {
+	case err := <-c:
+		t.abort = oc
+		return err
+	case <-t.done():
+		// The thread is abandoned, and keeps aborting to c.
+		return t.limits.ctx.Err()
+	}
+}
+
+// limitError returns the error r if it was raised because t's run
+// exceeded its limits, and nil otherwise.  Such errors unwind the
+// whole run; scripts cannot recover them.
+func (t *Thread) limitError(r interface{}) error {
+	switch r := r.(type) {
+	case *BudgetError:
+		return r
+	case *MemoryError:
+		return r
+	case *StackOverflowError:
+		return r
+	case error:
+		if t.limits != nil && r == t.limits.ctx.Err() {
+			return r
+		}
+	}
+	return nil
+}
+
+// run executes f as the body of a new goroutine.  An error that
+// escapes f cannot be returned to anybody, so instead of taking the
+// host program down with it, it is logged.
+func (t *Thread) run(f func(t *Thread)) {
+	if err := t.Try(f); err != nil && (t.limits == nil || err != t.limits.ctx.Err()) {
+		log.Print("goroutine: ", err)
+	}
+}
+
+// A PanicError is returned when a script panics and no deferred call
+// recovers.
+type PanicError struct {
+	// The value passed to panic.
+	Value Interface
+}
+
+func (e *PanicError) Error() string {
+	if e.Value.Value == nil {
+		return "panic: nil"
+	}
+	return "panic: " + e.Value.Value.String()
+}
+
+// Unwrap returns the run-time error the panic was raised with, if
+// any.
+func (e *PanicError) Unwrap() error {
+	if v, ok := e.Value.Value.(*opaqueV); ok {
+		if err, ok := v.rv.Interface().(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// toPanicError converts a value recovered from a Go panic into a
+// PanicError.  Panics raised by native code carry values the
+// interpreter cannot represent, so they are converted to their string
+// form.
+func toPanicError(r interface{}) *PanicError {
+	if p, ok := r.(*PanicError); ok {
+		return p
+	}
+	s := stringV(fmt.Sprint(r))
+	return &PanicError{Interface{StringType, &s}}
+}
+
+// ErrBudgetExceeded is the error a BudgetError wraps.
+var ErrBudgetExceeded = errors.New("instruction budget exceeded")
+
+// A BudgetError is returned when a run executes more instructions
+// than its Spec allows.
+type BudgetError struct {
+	// The number of instructions executed.
+	Steps int64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%v after %d steps", ErrBudgetExceeded, e.Steps)
+}
+
+func (e *BudgetError) Unwrap() error { return ErrBudgetExceeded }
+
+// ErrMemoryLimit is the error a MemoryError wraps.
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// A MemoryError is returned when a run allocates more memory than the
+// Spec of its World allows.
+type MemoryError struct {
+	// The memory limit, in bytes.
+	Limit int64
+}
+
+func (e *MemoryError) Error() string {
+	return fmt.Sprintf("%v: limit is %d bytes", ErrMemoryLimit, e.Limit)
+}
+
+func (e *MemoryError) Unwrap() error { return ErrMemoryLimit }
+
+// A StackOverflowError is returned when interpreted calls nest deeper
+// than the Spec allows.
+type StackOverflowError struct {
+	// The maximum call depth.
+	Depth int
+}
+
+func (e *StackOverflowError) Error() string {
+	return fmt.Sprintf("stack overflow: call depth exceeds %d", e.Depth)
 }
 
 type DivByZeroError struct{}
@@ -66,6 +206,27 @@ func (e SliceError) Error() string {
 	return fmt.Sprintf("slice [%d:%d]; cap %d", e.Lo, e.Hi, e.Cap)
 }
 
+// A TypeAssertionError is returned when a type assertion fails.
+type TypeAssertionError struct {
+	// The static type of the asserted expression, its dynamic
+	// type, or nil if it was a nil interface value, and the
+	// asserted type.
+	Interface, Concrete, Asserted Type
+	// The method that Concrete is missing, if Asserted is an
+	// interface type.
+	Missing string
+}
+
+func (e TypeAssertionError) Error() string {
+	switch {
+	case e.Concrete == nil:
+		return fmt.Sprintf("interface conversion: interface is nil, not %v", e.Asserted)
+	case e.Missing != "":
+		return fmt.Sprintf("interface conversion: %v is not %v: missing method %s", e.Concrete, e.Asserted, e.Missing)
+	}
+	return fmt.Sprintf("interface conversion: %v is %v, not %v", e.Interface, e.Concrete, e.Asserted)
+}
+
 type KeyError struct {
 	Key interface{}
 }
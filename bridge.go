@@ -5,10 +5,14 @@
 package chicklet
 
 import (
+	"context"
+	"go/ast"
 	"go/token"
-	"log"
+	"math/big"
 	"reflect"
 	"fmt"
+	"sync"
+	"sync/atomic"
 )
 
 /*
@@ -16,26 +20,22 @@ import (
  */
 
 var (
-	evalTypes   = make(map[reflect.Type]Type)
+	// bridgeMu guards the maps of converted types below, which are
+	// shared by all Worlds, and the native types of struct types.
+	bridgeMu  sync.Mutex
+	evalTypes = make(map[reflect.Type]Type)
+	// The native types of named types converted from native code.
 	nativeTypes = make(map[Type]reflect.Type)
+	// The native types of struct types declared by scripts.
+	scriptStructTypes = make(map[*StructType]reflect.Type)
 )
 
 func ValueFromNative(t Thing, thread *Thread) Value {
 	typ := reflect.TypeOf(t)
 	switch typ.Kind() {
 	case reflect.Func:
-		val := reflect.ValueOf(t)
-		_, fval := FuncFromNativeTyped(func(thread *Thread, in, out []Value) {
-			var reflect_in []reflect.Value
-			for _, inv := range in {
-				reflect_in = append(reflect_in, reflect.ValueOf(inv.GetNative(thread)))
-			}
-			reflect_out := val.Call(reflect_in)
-			for index, outv := range reflect_out {
-				out[index] = ValueFromNative(outv.Interface(), thread)
-			}
-		}, t)
-		return fval
+		ft := TypeFromNative(typ).(*FuncType)
+		return FuncFromNative(nativeCaller(reflect.ValueOf(t), ft), ft)
 	}
 	return TypeFromNative(typ).create(t, thread)
 }
@@ -43,15 +43,33 @@ func ValueFromNative(t Thing, thread *Thread) Value {
 // TypeFromNative converts a regular Go type into a the corresponding
 // interpreter Type.
 func TypeFromNative(t reflect.Type) Type {
+	bridgeMu.Lock()
+	defer bridgeMu.Unlock()
+	return typeFromNative(t)
+}
+
+// typeFromNative is TypeFromNative for callers holding bridgeMu.
+func typeFromNative(t reflect.Type) Type {
 	if et, ok := evalTypes[t]; ok {
 		return et
 	}
 
+	// Every named type gets its own NamedType, except the
+	// predeclared ones, which are the universe's.
 	var nt *NamedType
-	if t.Name() != "" {
-		name := t.PkgPath() + "·" + t.Name()
-		nt = &NamedType{token.NoPos, name, nil, true, make(map[string]Method)}
+	if t.Name() != "" && (t.PkgPath() != "" || t.Kind() == reflect.Interface) {
+		name := t.Name()
+		if t.PkgPath() != "" {
+			name = t.PkgPath() + "·" + name
+		}
+		nt = &NamedType{token.NoPos, name, nil, true, make(map[string]*Method)}
 		evalTypes[t] = nt
+		defer func() {
+			// Forget the placeholder if t could not be converted.
+			if nt.incomplete {
+				delete(evalTypes, t)
+			}
+		}()
 	}
 
 	var et Type
@@ -91,67 +109,1229 @@ func TypeFromNative(t reflect.Type) Type {
 	case reflect.String:
 		et = StringType
 	case reflect.Array:
-		et = NewArrayType(int64(t.Len()), TypeFromNative(t.Elem()))
+		et = NewArrayType(int64(t.Len()), typeFromNative(t.Elem()))
 	case reflect.Chan:
-		log.Panicf("%T not implemented", t)
+		panic(&ConvertError{fmt.Sprintf("native type %v not supported", t)})
 	case reflect.Func:
-		nin := t.NumIn()
-		// Variadic functions have DotDotDotType at the end
-		variadic := t.IsVariadic()
-		if variadic {
-			nin--
-		}
-		in := make([]Type, nin)
+		// The final parameter of a variadic function is a slice.
+		in := make([]Type, t.NumIn())
 		for i := range in {
-			in[i] = TypeFromNative(t.In(i))
+			in[i] = typeFromNative(t.In(i))
 		}
 		out := make([]Type, t.NumOut())
 		for i := range out {
-			out[i] = TypeFromNative(t.Out(i))
+			out[i] = typeFromNative(t.Out(i))
 		}
-		et = NewFuncType(in, variadic, out)
+		et = NewFuncType(in, t.IsVariadic(), out)
 	case reflect.Interface:
-		log.Panicf("%T not implemented", t)
+		methods := make([]IMethod, t.NumMethod())
+		for i := range methods {
+			m := t.Method(i)
+			methods[i] = IMethod{m.Name, typeFromNative(m.Type).(*FuncType)}
+		}
+		et = NewInterfaceType(methods, nil)
 	case reflect.Map:
-		log.Panicf("%T not implemented", t)
+		et = NewMapType(typeFromNative(t.Key()), typeFromNative(t.Elem()))
 	case reflect.Ptr:
-		et = NewPtrType(TypeFromNative(t.Elem()))
+		et = NewPtrType(typeFromNative(t.Elem()))
 	case reflect.Slice:
-		et = NewSliceType(TypeFromNative(t.Elem()))
+		et = NewSliceType(typeFromNative(t.Elem()))
 	case reflect.Struct:
-		n := t.NumField()
-		fields := make([]StructField, n)
-		for i := 0; i < n; i++ {
-			sf := t.Field(i)
-			// TODO(austin) What to do about private fields?
-			fields[i].Name = sf.Name
-			fields[i].Type = TypeFromNative(sf.Type)
-			fields[i].Anonymous = sf.Anonymous
+		// Scripts only see the visible fields.  The others are
+		// kept in the native value and restored by GetNative.
+		index := nativeFieldIndex(t)
+		fields := make([]StructField, len(index))
+		names := make(map[string]bool)
+		for i, fi := range index {
+			sf := t.Field(fi)
+			name, _ := nativeFieldName(sf)
+			if names[name] {
+				panic(&ConvertError{fmt.Sprintf("duplicate field %s in native type %v", name, t)})
+			}
+			names[name] = true
+			fields[i].Name = name
+			fields[i].Type = typeFromNative(sf.Type)
+			fields[i].Anonymous = sf.Anonymous && name == sf.Name
 		}
 		et = NewStructType(fields)
 		et.(*StructType).nativeType = t
 	case reflect.UnsafePointer:
-		log.Panicf("%T not implemented", t)
+		panic(&ConvertError{fmt.Sprintf("native type %v not supported", t)})
 	default:
-		log.Panicf("unexpected reflect.Type: %T", t)
+		panic(&ConvertError{fmt.Sprintf("native type %v not supported", t)})
 	}
 
 	if nt != nil {
-		if _, ok := et.(*NamedType); !ok {
-			nt.Complete(et)
-			et = nt
-		}
+		nt.Complete(et)
+		et = nt
 	}
 
-	nativeTypes[et] = t
+	// Only named types are distinct enough to stand for a single
+	// native type; the others are found by structure.
+	if nt != nil {
+		nativeTypes[nt] = t
+	}
 	evalTypes[t] = et
 
+	if nt != nil && et == nt && t.Kind() != reflect.Interface {
+		bindNativeMethods(nt, t)
+	}
+
 	return et
 }
 
+// bindNativeMethods installs the methods of the native type rt and of
+// *rt as methods of nt, calling through reflection.  Methods whose
+// signatures cannot be represented are left out.
+func bindNativeMethods(nt *NamedType, rt reflect.Type) {
+	pt := reflect.PtrTo(rt)
+	for i := 0; i < pt.NumMethod(); i++ {
+		m := pt.Method(i)
+		var recv Type = nt
+		if vm, ok := rt.MethodByName(m.Name); ok {
+			m = vm
+		} else {
+			recv = NewPtrType(nt)
+		}
+		ft, ok := nativeMethodType(m.Type)
+		if !ok {
+			continue
+		}
+		in := make([]Type, len(ft.In)+1)
+		in[0] = recv
+		copy(in[1:], ft.In)
+		fn := &nativeFunc{nativeCaller(m.Func, NewFuncType(in, ft.Variadic, ft.Out)), len(in), len(ft.Out)}
+		nt.methods[m.Name] = &Method{&FuncDecl{Type: ft, Name: ast.NewIdent(m.Name)}, recv, fn}
+	}
+}
+
+// nativeMethodType returns the interpreter type of a native method of
+// type mt without its receiver, if its signature can be represented.
+// The caller holds bridgeMu.
+func nativeMethodType(mt reflect.Type) (ft *FuncType, ok bool) {
+	in := make([]reflect.Type, mt.NumIn()-1)
+	for i := range in {
+		in[i] = mt.In(i + 1)
+	}
+	out := make([]reflect.Type, mt.NumOut())
+	for i := range out {
+		out[i] = mt.Out(i)
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if _, isConv := e.(*ConvertError); !isConv {
+				panic(e)
+			}
+			ft, ok = nil, false
+		}
+	}()
+	return typeFromNative(reflect.FuncOf(in, out, mt.IsVariadic())).(*FuncType), true
+}
+
 // TypeOfNative returns the interpreter Type of a regular Go value.
 func TypeOfNative(v interface{}) Type { return TypeFromNative(reflect.TypeOf(v)) }
 
+var emptyInterfaceNative = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// nativeTypeOf returns the native Go type that values of the
+// interpreter type t are converted to by GetNative.  Types without a
+// native equivalent, such as struct types declared by scripts, are
+// represented by interface{}.
+func nativeTypeOf(t Type) reflect.Type {
+	bridgeMu.Lock()
+	defer bridgeMu.Unlock()
+	return nativeTypeOfLocked(t)
+}
+
+// nativeTypeOfLocked is nativeTypeOf for callers holding bridgeMu.
+func nativeTypeOfLocked(t Type) reflect.Type {
+	if rt, ok := nativeTypes[t]; ok {
+		return rt
+	}
+	switch t := t.lit().(type) {
+	case *boolType:
+		return reflect.TypeOf(false)
+	case *uintType:
+		switch t.Bits {
+		case 8:
+			return reflect.TypeOf(uint8(0))
+		case 16:
+			return reflect.TypeOf(uint16(0))
+		case 32:
+			return reflect.TypeOf(uint32(0))
+		case 64:
+			return reflect.TypeOf(uint64(0))
+		}
+		if t.Ptr {
+			return reflect.TypeOf(uintptr(0))
+		}
+		return reflect.TypeOf(uint(0))
+	case *intType:
+		switch t.Bits {
+		case 8:
+			return reflect.TypeOf(int8(0))
+		case 16:
+			return reflect.TypeOf(int16(0))
+		case 32:
+			return reflect.TypeOf(int32(0))
+		case 64:
+			return reflect.TypeOf(int64(0))
+		}
+		return reflect.TypeOf(int(0))
+	case *floatType:
+		if t.Bits == 32 {
+			return reflect.TypeOf(float32(0))
+		}
+		return reflect.TypeOf(float64(0))
+	case *stringType:
+		return reflect.TypeOf("")
+	case *MapType:
+		kt := nativeTypeOfLocked(t.Key)
+		if kt.Comparable() {
+			return reflect.MapOf(kt, nativeTypeOfLocked(t.Elem))
+		}
+	case *ArrayType:
+		return reflect.ArrayOf(int(t.Len), nativeTypeOfLocked(t.Elem))
+	case *PtrType:
+		// Native struct types cannot refer to themselves, so
+		// pointers to a script struct type being converted are
+		// represented by interface{}.
+		if st, ok := t.Elem.lit().(*StructType); ok && st.nativeType == nil && scriptStructTypes[st] == emptyInterfaceNative {
+			return emptyInterfaceNative
+		}
+		return reflect.PtrTo(nativeTypeOfLocked(t.Elem))
+	case *SliceType:
+		return reflect.SliceOf(nativeTypeOfLocked(t.Elem))
+	case *FuncType:
+		in := make([]reflect.Type, len(t.In))
+		for i, it := range t.In {
+			in[i] = nativeTypeOfLocked(it)
+		}
+		out := make([]reflect.Type, len(t.Out))
+		for i, ot := range t.Out {
+			out[i] = nativeTypeOfLocked(ot)
+		}
+		return reflect.FuncOf(in, out, t.Variadic)
+	case *StructType:
+		if t.nativeType != nil {
+			return t.nativeType
+		}
+		return scriptStructType(t)
+	}
+	return emptyInterfaceNative
+}
+
+// scriptStructType returns the native type of values of the struct
+// type t declared by a script: a struct of its exported fields.  Its
+// other fields are not visible to native code.  The caller holds
+// bridgeMu.
+func scriptStructType(t *StructType) reflect.Type {
+	if rt, ok := scriptStructTypes[t]; ok {
+		return rt
+	}
+	// Within itself, a recursive struct type is represented by
+	// interface{}.
+	scriptStructTypes[t] = emptyInterfaceNative
+	var fields []reflect.StructField
+	for _, f := range t.Elems {
+		if ast.IsExported(f.Name) {
+			fields = append(fields, reflect.StructField{Name: f.Name, Type: nativeTypeOfLocked(f.Type)})
+		}
+	}
+	rt := reflect.StructOf(fields)
+	scriptStructTypes[t] = rt
+	return rt
+}
+
+// nativeScriptStruct returns the native form of the value v of the
+// struct type t declared by a script.
+func nativeScriptStruct(th *Thread, v StructValue, t *StructType) Thing {
+	rv := reflect.New(nativeTypeOf(t)).Elem()
+	for i, f := range t.Elems {
+		if ast.IsExported(f.Name) {
+			fv := rv.FieldByName(f.Name)
+			fv.Set(nativeValue(th, v.Field(th, i), fv.Type()))
+		}
+	}
+	return rv.Interface()
+}
+
+// nativeValue returns the native form of v as a value of the native
+// type rt.
+func nativeValue(t *Thread, v Value, rt reflect.Type) reflect.Value {
+	if iv, ok := v.(InterfaceValue); ok && rt.Kind() == reflect.Interface {
+		return nativeInterface(t, iv.Get(t), rt)
+	}
+	if sv, ok := v.(SliceValue); ok && rt.Kind() == reflect.Slice {
+		return nativeSlice(t, sv.Get(t), rt)
+	}
+	if av, ok := v.(ArrayValue); ok && rt.Kind() == reflect.Array {
+		return nativeArray(t, av, rt)
+	}
+	x := v.GetNative(t)
+	if x == nil {
+		return reflect.Zero(rt)
+	}
+	rv := reflect.ValueOf(x)
+	switch {
+	case rv.Type().AssignableTo(rt):
+	case rv.Type().ConvertibleTo(rt):
+		rv = rv.Convert(rt)
+	default:
+		panic(&ConvertError{fmt.Sprintf("cannot convert %v to native type %v", rv.Type(), rt)})
+	}
+	return rv
+}
+
+// nativeSlice returns the native slice of type rt for s.  Unless s is
+// backed by native memory, its elements are copied, and the copy is
+// written back when the native call it is passed to returns.
+func nativeSlice(t *Thread, s Slice, rt reflect.Type) reflect.Value {
+	if s.Base == nil {
+		return reflect.Zero(rt)
+	}
+	// Slices of native arrays share them.
+	if r, ok := s.Base.(refArrayV); ok && r.rv.Type().Elem() == rt.Elem() {
+		return r.rv.Slice3(0, int(s.Len), int(s.Cap)).Convert(rt)
+	}
+	rv := reflect.MakeSlice(rt, int(s.Len), int(s.Len))
+	for i := int64(0); i < s.Len; i++ {
+		rv.Index(int(i)).Set(nativeValue(t, s.Base.Elem(t, i), rt.Elem()))
+	}
+	t.copied(s.Base, rv, nil)
+	return rv
+}
+
+// nativeArray returns a copy of a as a native array of type rt.
+func nativeArray(t *Thread, a ArrayValue, rt reflect.Type) reflect.Value {
+	rv := reflect.New(rt).Elem()
+	if r, ok := a.(refArrayV); ok && r.rv.Type().ConvertibleTo(rt) {
+		rv.Set(r.rv.Convert(rt))
+		return rv
+	}
+	for i := 0; i < rt.Len(); i++ {
+		rv.Index(i).Set(nativeValue(t, a.Elem(t, int64(i)), rt.Elem()))
+	}
+	return rv
+}
+
+// A nativeCopy is a native copy of script memory passed to native
+// code.  v is the script array or pointer target it was made from.
+// For a pointer, ptr is the script pointer and rv the native one.
+type nativeCopy struct {
+	v   Value
+	rv  reflect.Value
+	ptr *ptrV
+}
+
+// copied records that rv is a native copy of v, if t is calling
+// native code.
+func (t *Thread) copied(v Value, rv reflect.Value, ptr *ptrV) {
+	if t != nil && t.copies != nil {
+		t.copies = append(t.copies, nativeCopy{v, rv, ptr})
+	}
+}
+
+// writeBack stores the changes native code made to the copies of
+// script memory passed to it back into the script values, as if it
+// had shared them.
+func (t *Thread) writeBack(copies []nativeCopy) {
+	// Pointers native code moved around are matched to the script
+	// pointers they were made from.
+	targets := make(map[uintptr]Value)
+	for _, c := range copies {
+		if c.ptr != nil {
+			targets[c.rv.Pointer()] = c.v
+		}
+	}
+	for _, c := range copies {
+		if c.ptr != nil {
+			storeNative(t, c.v, c.rv.Elem(), targets)
+			continue
+		}
+		a := c.v.(ArrayValue)
+		for i := 0; i < c.rv.Len(); i++ {
+			storeNative(t, a.Elem(t, int64(i)), c.rv.Index(i), targets)
+		}
+	}
+}
+
+// storeNative sets v to the native value rv that native code may have
+// changed.  Maps, functions, channels and interfaces are left alone;
+// maps and channels are shared anyway.
+func storeNative(t *Thread, v Value, rv reflect.Value, targets map[uintptr]Value) {
+	switch v := v.(type) {
+	case refValue:
+		// Native memory is shared already.
+	case BoolValue:
+		if rv.Kind() == reflect.Bool {
+			v.Set(t, rv.Bool())
+		}
+	case UintValue:
+		switch rv.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			v.Set(t, rv.Uint())
+		}
+	case IntValue:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v.Set(t, rv.Int())
+		}
+	case FloatValue:
+		if k := rv.Kind(); k == reflect.Float32 || k == reflect.Float64 {
+			v.Set(t, rv.Float())
+		}
+	case StringValue:
+		if rv.Kind() == reflect.String {
+			v.Set(t, rv.String())
+		}
+	case ArrayValue:
+		if rv.Kind() == reflect.Array {
+			for i := 0; i < rv.Len(); i++ {
+				storeNative(t, v.Elem(t, int64(i)), rv.Index(i), targets)
+			}
+		}
+	case *structV:
+		if rv.Kind() != reflect.Struct {
+			return
+		}
+		st := v.typ.lit().(*StructType)
+		bridgeMu.Lock()
+		nativeType := st.nativeType
+		bridgeMu.Unlock()
+		if nativeType != nil {
+			copy := reflect.New(rv.Type()).Elem()
+			copy.Set(rv)
+			v.Assign(t, refStructV{copy, st})
+			return
+		}
+		for i, f := range st.Elems {
+			if ast.IsExported(f.Name) {
+				storeNative(t, v.Field(t, i), rv.FieldByName(f.Name), targets)
+			}
+		}
+	case *ptrV:
+		if rv.Kind() != reflect.Ptr {
+			return
+		}
+		if rv.IsNil() {
+			v.target = nil
+		} else if target, ok := targets[rv.Pointer()]; ok {
+			v.target = target
+		} else if v.typ != nil {
+			v.target = refFromNative(rv.Elem(), v.typ.Elem)
+		}
+	}
+}
+
+// valueFromReflect converts the native value rv to a Value of type
+// et, the interpreter type of rv's static type.
+func valueFromReflect(t *Thread, rv reflect.Value, et Type) Value {
+	switch rv.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		if rv.IsNil() {
+			return et.Zero()
+		}
+	}
+	if !rv.CanInterface() {
+		panic(&ConvertError{fmt.Sprintf("cannot convert unexported native value of type %v", rv.Type())})
+	}
+	return valueOfType(t, rv.Interface(), et)
+}
+
+// valueOfType converts the native value x to a Value of type et.
+// Unlike ValueFromNative, this keeps the static type of interface
+// values.
+func valueOfType(t *Thread, x Thing, et Type) Value {
+	if x == nil {
+		return et.Zero()
+	}
+	if _, ok := et.lit().(*InterfaceType); ok {
+		return et.create(x, t)
+	}
+	return ValueFromNative(x, t)
+}
+
+// nativeThing is like v.GetNative, but converts v to the native type
+// of typ, so that script values returned as native interfaces are
+// wrapped in their proxies.
+func nativeThing(t *Thread, v Value, typ Type) Thing {
+	return nativeValue(t, v, nativeTypeOf(typ)).Interface()
+}
+
+/*
+ * Interface bridging
+ */
+
+var errorNative = reflect.TypeOf((*error)(nil)).Elem()
+
+func init() {
+	// The predeclared error type is the native one, so errors pass
+	// between scripts and native code unchanged.
+	universe.defs["error"] = TypeFromNative(errorNative)
+}
+
+// An opaqueType is the dynamic type of a native value held in an
+// interface when the value's type has methods or no interpreter
+// equivalent.  Scripts can only call its methods, compare it and
+// assert it to interface types.
+type opaqueType struct {
+	commonType
+	rt reflect.Type
+}
+
+var opaqueTypes = make(map[reflect.Type]*opaqueType)
+
+func newOpaqueType(rt reflect.Type) *opaqueType {
+	bridgeMu.Lock()
+	defer bridgeMu.Unlock()
+	t, ok := opaqueTypes[rt]
+	if !ok {
+		t = &opaqueType{commonType{}, rt}
+		opaqueTypes[rt] = t
+	}
+	return t
+}
+
+func (t *opaqueType) compat(o Type, conv bool) bool { return t == o }
+
+func (t *opaqueType) lit() Type { return t }
+
+func (t *opaqueType) String() string { return t.rt.String() }
+
+func (t *opaqueType) Zero() Value { return &opaqueV{reflect.Zero(t.rt)} }
+
+func (t *opaqueType) create(v Thing, thread *Thread) Value {
+	return &opaqueV{reflect.ValueOf(v)}
+}
+
+// methodType returns the interpreter type of the method called name,
+// if t has such a method and its signature can be represented.
+func (t *opaqueType) methodType(name string) (*FuncType, bool) {
+	m, ok := t.rt.MethodByName(name)
+	if !ok {
+		return nil, false
+	}
+	bridgeMu.Lock()
+	defer bridgeMu.Unlock()
+	return nativeMethodType(m.Type)
+}
+
+// method returns the method called name of the opaque value v.
+func (t *opaqueType) method(v Value, name string, ft *FuncType) Func {
+	mv := v.(*opaqueV).rv.MethodByName(name)
+	return &nativeFunc{nativeCaller(mv, ft), len(ft.In), len(ft.Out)}
+}
+
+type opaqueV struct {
+	rv reflect.Value
+}
+
+func (v *opaqueV) String() string { return fmt.Sprint(v.rv.Interface()) }
+
+func (v *opaqueV) Assign(t *Thread, o Value) { v.rv = o.(*opaqueV).rv }
+
+func (v *opaqueV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+// interfaceFromNative converts the dynamic value x of a native
+// interface to an Interface.  Proxies are unwrapped to the script
+// values they hold.  Values whose types have methods or cannot be
+// converted are kept opaque.
+func interfaceFromNative(t *Thread, x Thing) Interface {
+	if x == nil {
+		return Interface{}
+	}
+	if p, ok := x.(scriptProxy); ok {
+		return p.scriptValue().i
+	}
+	rt := reflect.TypeOf(x)
+	if rt.NumMethod() == 0 {
+		if i, ok := tryFromNative(t, x); ok {
+			return i
+		}
+	}
+	return Interface{newOpaqueType(rt), &opaqueV{reflect.ValueOf(x)}}
+}
+
+func tryFromNative(t *Thread, x Thing) (i Interface, ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if _, isConv := e.(*ConvertError); !isConv {
+				panic(e)
+			}
+		}
+	}()
+	return Interface{TypeOfNative(x), ValueFromNative(x, t)}, true
+}
+
+// nativeInterface returns the native form of the interface value i
+// for the native interface type rt.  Script values are handed to
+// interfaces with methods through the proxy registered for rt.
+func nativeInterface(t *Thread, i Interface, rt reflect.Type) reflect.Value {
+	if i.Type == nil {
+		return reflect.Zero(rt)
+	}
+	if v, ok := i.Value.(*opaqueV); ok {
+		return v.rv
+	}
+	if rt.NumMethod() == 0 {
+		// Script arrays do not know their own type.
+		if _, ok := i.Type.lit().(*ArrayType); ok {
+			return nativeValue(t, i.Value, nativeTypeOf(i.Type))
+		}
+		x := i.Value.GetNative(t)
+		if x == nil {
+			return reflect.Zero(rt)
+		}
+		return reflect.ValueOf(x)
+	}
+	bridgeMu.Lock()
+	wrap, ok := proxies[rt]
+	bridgeMu.Unlock()
+	if !ok {
+		panic(&ConvertError{fmt.Sprintf("no proxy for native interface %v", rt)})
+	}
+	return reflect.ValueOf(wrap(&ScriptValue{i}))
+}
+
+// A ScriptValue is a script value with methods, as seen by native
+// code.  Proxies wrap a ScriptValue to implement native interfaces.
+type ScriptValue struct {
+	i Interface
+}
+
+func (s *ScriptValue) scriptValue() *ScriptValue { return s }
+
+// scriptProxy is implemented by proxies that embed a *ScriptValue.
+type scriptProxy interface {
+	scriptValue() *ScriptValue
+}
+
+// Call calls the method called name of the script value.
+func (s *ScriptValue) Call(name string, args ...Thing) ([]Thing, error) {
+	m, recv := findMethod(s.i.Type, name)
+	if m == nil {
+		return nil, &CallError{fmt.Sprintf("%v has no method %s", s.i.Type, name)}
+	}
+	ft := m.decl.Type
+	if len(args) != len(ft.In) {
+		return nil, &CallError{fmt.Sprint("Wrong number of arguments. Wanted ", len(ft.In), " but got ", len(args))}
+	}
+	var rval []Thing
+	thread := &Thread{}
+	err := thread.Try(func(t *Thread) {
+		f := &boundMethod{m, recv(t, s.i.Value)}
+		frame := f.NewFrame()
+		for index, arg := range args {
+			frame.Vars[index] = valueOfType(t, arg, ft.In[index])
+		}
+		for index, ot := range ft.Out {
+			frame.Vars[len(ft.In)+index] = ot.Zero()
+		}
+		t.f = frame
+		f.Call(t)
+		for index, ot := range ft.Out {
+			rval = append(rval, nativeThing(t, frame.Vars[len(ft.In)+index], ot))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rval, nil
+}
+
+var proxies = map[reflect.Type]func(*ScriptValue) interface{}{
+	errorNative: func(s *ScriptValue) interface{} { return errorProxy{s} },
+	reflect.TypeOf((*fmt.Stringer)(nil)).Elem(): func(s *ScriptValue) interface{} { return stringerProxy{s} },
+}
+
+// RegisterProxy registers wrap as the way to hand script values to
+// native code expecting the native interface type iface.  The value
+// returned by wrap must implement iface, typically by embedding the
+// *ScriptValue and forwarding each method to its Call method.
+func RegisterProxy(iface reflect.Type, wrap func(*ScriptValue) interface{}) {
+	bridgeMu.Lock()
+	defer bridgeMu.Unlock()
+	proxies[iface] = wrap
+}
+
+type errorProxy struct{ *ScriptValue }
+
+func (p errorProxy) Error() string {
+	res, err := p.Call("Error")
+	if err != nil {
+		return err.Error()
+	}
+	return res[0].(string)
+}
+
+type stringerProxy struct{ *ScriptValue }
+
+func (p stringerProxy) String() string {
+	res, err := p.Call("String")
+	if err != nil {
+		return err.Error()
+	}
+	return res[0].(string)
+}
+
+// nativeFieldName returns the name scripts use for the native struct
+// field sf, which may be changed with a `chicklet:"name"` tag.  It
+// returns false if the field is hidden from scripts, because it is
+// unexported or tagged `chicklet:"-"`.
+func nativeFieldName(sf reflect.StructField) (string, bool) {
+	tag := sf.Tag.Get("chicklet")
+	if sf.PkgPath != "" || tag == "-" {
+		return "", false
+	}
+	if tag == "" {
+		return sf.Name, true
+	}
+	if !token.IsIdentifier(tag) {
+		panic(&ConvertError{fmt.Sprintf("invalid chicklet tag %q on field %s", tag, sf.Name)})
+	}
+	return tag, true
+}
+
+// nativeFieldIndex returns the indices of the fields of the native
+// struct type rt that are visible to scripts, in order.
+func nativeFieldIndex(rt reflect.Type) []int {
+	var index []int
+	for i := 0; i < rt.NumField(); i++ {
+		if _, ok := nativeFieldName(rt.Field(i)); ok {
+			index = append(index, i)
+		}
+	}
+	return index
+}
+
+/*
+ * Aliased native memory
+ */
+
+// A refValue is a Value that lives in native memory.  Values reached
+// through native pointers are refValues, so scripts and the host see
+// each other's changes immediately.
+type refValue interface {
+	Value
+	ref() reflect.Value
+}
+
+// refFromNative returns a Value of type t backed by the addressable
+// native value rv.
+func refFromNative(rv reflect.Value, t Type) Value {
+	if !rv.IsValid() {
+		panic(&ConvertError{fmt.Sprintf("cannot alias nil native value of type %v", t)})
+	}
+	if !rv.CanSet() {
+		panic(&ConvertError{fmt.Sprintf("cannot alias unexported native value of type %v", rv.Type())})
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return refBoolV{rv}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return refUintV{rv}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return refIntV{rv}
+	case reflect.Float32, reflect.Float64:
+		return refFloatV{rv}
+	case reflect.String:
+		return refStringV{rv}
+	case reflect.Struct:
+		return refStructV{rv, t.lit().(*StructType)}
+	case reflect.Ptr:
+		return refPtrV{rv, t.lit().(*PtrType)}
+	case reflect.Map:
+		return refMapV{rv, t.lit().(*MapType)}
+	case reflect.Func:
+		return refFuncV{rv, t.lit().(*FuncType)}
+	case reflect.Interface:
+		return refInterfaceV{rv}
+	case reflect.Array:
+		return refArrayV{rv, t.lit().(*ArrayType).Elem}
+	case reflect.Slice:
+		return refSliceV{rv, t.lit().(*SliceType)}
+	}
+	panic(&ConvertError{fmt.Sprintf("cannot alias native value of type %v", rv.Type())})
+}
+
+type refBoolV struct{ rv reflect.Value }
+
+func (v refBoolV) String() string { return fmt.Sprint(v.rv.Bool()) }
+
+func (v refBoolV) Assign(t *Thread, o Value) { v.Set(t, o.(BoolValue).Get(t)) }
+
+func (v refBoolV) Get(*Thread) bool { return v.rv.Bool() }
+
+func (v refBoolV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refBoolV) Set(t *Thread, x bool) { v.rv.SetBool(x) }
+
+func (v refBoolV) ref() reflect.Value { return v.rv }
+
+type refUintV struct{ rv reflect.Value }
+
+func (v refUintV) String() string { return fmt.Sprint(v.rv.Uint()) }
+
+func (v refUintV) Assign(t *Thread, o Value) { v.Set(t, o.(UintValue).Get(t)) }
+
+func (v refUintV) Get(*Thread) uint64 { return v.rv.Uint() }
+
+func (v refUintV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refUintV) Set(t *Thread, x uint64) { v.rv.SetUint(x) }
+
+func (v refUintV) ref() reflect.Value { return v.rv }
+
+type refIntV struct{ rv reflect.Value }
+
+func (v refIntV) String() string { return fmt.Sprint(v.rv.Int()) }
+
+func (v refIntV) Assign(t *Thread, o Value) { v.Set(t, o.(IntValue).Get(t)) }
+
+func (v refIntV) Get(*Thread) int64 { return v.rv.Int() }
+
+func (v refIntV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refIntV) Set(t *Thread, x int64) { v.rv.SetInt(x) }
+
+func (v refIntV) ref() reflect.Value { return v.rv }
+
+type refFloatV struct{ rv reflect.Value }
+
+func (v refFloatV) String() string { return fmt.Sprint(v.rv.Float()) }
+
+func (v refFloatV) Assign(t *Thread, o Value) { v.Set(t, o.(FloatValue).Get(t)) }
+
+func (v refFloatV) Get(*Thread) float64 { return v.rv.Float() }
+
+func (v refFloatV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refFloatV) Set(t *Thread, x float64) { v.rv.SetFloat(x) }
+
+func (v refFloatV) ref() reflect.Value { return v.rv }
+
+type refStringV struct{ rv reflect.Value }
+
+func (v refStringV) String() string { return v.rv.String() }
+
+func (v refStringV) Assign(t *Thread, o Value) { v.Set(t, o.(StringValue).Get(t)) }
+
+func (v refStringV) Get(*Thread) string { return v.rv.String() }
+
+func (v refStringV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refStringV) Set(t *Thread, x string) { v.rv.SetString(x) }
+
+func (v refStringV) ref() reflect.Value { return v.rv }
+
+type refStructV struct {
+	rv  reflect.Value
+	typ *StructType
+}
+
+func (v refStructV) String() string {
+	res := "{"
+	for i := range v.typ.Elems {
+		if i > 0 {
+			res += ", "
+		}
+		res += v.Field(nil, i).String()
+	}
+	return res + "}"
+}
+
+func (v refStructV) Assign(t *Thread, o Value) {
+	// Hidden fields are copied along with the native value.
+	switch o := o.(type) {
+	case refStructV:
+		if o.rv.Type() == v.rv.Type() {
+			v.rv.Set(o.rv)
+			return
+		}
+	case *structV:
+		if o.native.IsValid() && o.native.Type() == v.rv.Type() {
+			v.rv.Set(o.native)
+		} else {
+			v.rv.Set(reflect.Zero(v.rv.Type()))
+		}
+	}
+	oa := o.(StructValue)
+	for i := range v.typ.Elems {
+		v.Field(t, i).Assign(t, oa.Field(t, i))
+	}
+}
+
+func (v refStructV) Get(*Thread) StructValue { return v }
+
+func (v refStructV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refStructV) Field(t *Thread, i int) Value {
+	return refFromNative(v.rv.Field(nativeFieldIndex(v.rv.Type())[i]), v.typ.Elems[i].Type)
+}
+
+func (v refStructV) ref() reflect.Value { return v.rv }
+
+type refPtrV struct {
+	rv  reflect.Value
+	typ *PtrType
+}
+
+func (v refPtrV) String() string {
+	if v.rv.IsNil() {
+		return "<nil>"
+	}
+	return "&" + v.Get(nil).String()
+}
+
+func (v refPtrV) Assign(t *Thread, o Value) { v.Set(t, o.(PtrValue).Get(t)) }
+
+func (v refPtrV) Get(*Thread) Value {
+	if v.rv.IsNil() {
+		return nil
+	}
+	return refFromNative(v.rv.Elem(), v.typ.Elem)
+}
+
+func (v refPtrV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+// Set points v at x.  If x is not itself in native memory, it is
+// copied into newly allocated native memory.
+func (v refPtrV) Set(t *Thread, x Value) {
+	if x == nil {
+		v.rv.Set(reflect.Zero(v.rv.Type()))
+		return
+	}
+	if r, ok := x.(refValue); ok && r.ref().Type() == v.rv.Type().Elem() {
+		v.rv.Set(r.ref().Addr())
+		return
+	}
+	p := reflect.New(v.rv.Type().Elem())
+	refFromNative(p.Elem(), v.typ.Elem).Assign(t, x)
+	v.rv.Set(p)
+}
+
+func (v refPtrV) ref() reflect.Value { return v.rv }
+
+// A refMapV aliases a native map variable.  The map it holds is
+// shared too, so scripts and the host see each other's entries.
+type refMapV struct {
+	rv  reflect.Value
+	typ *MapType
+}
+
+func (v refMapV) String() string { return fmt.Sprint(v.rv.Interface()) }
+
+func (v refMapV) Assign(t *Thread, o Value) { v.Set(t, o.(MapValue).Get(t)) }
+
+func (v refMapV) Get(*Thread) Map {
+	if v.rv.IsNil() {
+		return nil
+	}
+	return nativeMap{v.rv, v.typ}
+}
+
+func (v refMapV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refMapV) Set(t *Thread, x Map) { v.rv.Set(nativeValue(t, &mapV{x, v.typ}, v.rv.Type())) }
+
+func (v refMapV) ref() reflect.Value { return v.rv }
+
+// A nativeMap is a Map stored in a native map.
+type nativeMap struct {
+	rv  reflect.Value
+	typ *MapType
+}
+
+func (m nativeMap) Len(*Thread) int64 { return int64(m.rv.Len()) }
+
+func (m nativeMap) key(t *Thread, key interface{}) reflect.Value {
+	return nativeValue(t, mapKeyValue(t, m.typ.Key, key), m.rv.Type().Key())
+}
+
+// Elem returns the element with the given key.  Native map elements
+// are not addressable, so it returns a copy whose assignments are
+// stored back into the map.
+func (m nativeMap) Elem(t *Thread, key interface{}) Value {
+	kv := m.key(t, key)
+	ev := m.rv.MapIndex(kv)
+	if !ev.IsValid() {
+		return nil
+	}
+	if ev.Kind() == reflect.Chan {
+		return valueFromReflect(t, ev, m.typ.Elem)
+	}
+	cell := reflect.New(ev.Type()).Elem()
+	cell.Set(ev)
+	return storedValue(refFromNative(cell, m.typ.Elem), func() { m.rv.SetMapIndex(kv, cell) })
+}
+
+func (m nativeMap) SetElem(t *Thread, key interface{}, val Value) {
+	if val == nil {
+		m.rv.SetMapIndex(m.key(t, key), reflect.Value{})
+		return
+	}
+	m.rv.SetMapIndex(m.key(t, key), nativeValue(t, val, m.rv.Type().Elem()))
+}
+
+func (m nativeMap) Iter(cb func(key interface{}, val Value) bool) {
+	t := &Thread{}
+	iter := m.rv.MapRange()
+	for iter.Next() {
+		k := valueFromReflect(t, iter.Key(), m.typ.Key)
+		if !cb(valueMapKey(t, k), valueFromReflect(t, iter.Value(), m.typ.Elem)) {
+			break
+		}
+	}
+}
+
+// storedValue returns v, which is a copy of some native memory, with
+// store called after each assignment to it.
+func storedValue(v Value, store func()) Value {
+	switch v := v.(type) {
+	case BoolValue:
+		return storedBoolV{v, store}
+	case UintValue:
+		return storedUintV{v, store}
+	case IntValue:
+		return storedIntV{v, store}
+	case FloatValue:
+		return storedFloatV{v, store}
+	case StringValue:
+		return storedStringV{v, store}
+	case StructValue:
+		return storedStructV{v, store}
+	case PtrValue:
+		return storedPtrV{v, store}
+	case MapValue:
+		return storedMapV{v, store}
+	case FuncValue:
+		return storedFuncV{v, store}
+	case InterfaceValue:
+		return storedInterfaceV{v, store}
+	case ArrayValue:
+		return storedArrayV{v, store}
+	case SliceValue:
+		return storedSliceV{v, store}
+	}
+	return v
+}
+
+type storedBoolV struct {
+	BoolValue
+	store func()
+}
+
+func (v storedBoolV) Assign(t *Thread, o Value) { v.BoolValue.Assign(t, o); v.store() }
+
+func (v storedBoolV) Set(t *Thread, x bool) { v.BoolValue.Set(t, x); v.store() }
+
+type storedUintV struct {
+	UintValue
+	store func()
+}
+
+func (v storedUintV) Assign(t *Thread, o Value) { v.UintValue.Assign(t, o); v.store() }
+
+func (v storedUintV) Set(t *Thread, x uint64) { v.UintValue.Set(t, x); v.store() }
+
+type storedIntV struct {
+	IntValue
+	store func()
+}
+
+func (v storedIntV) Assign(t *Thread, o Value) { v.IntValue.Assign(t, o); v.store() }
+
+func (v storedIntV) Set(t *Thread, x int64) { v.IntValue.Set(t, x); v.store() }
+
+type storedFloatV struct {
+	FloatValue
+	store func()
+}
+
+func (v storedFloatV) Assign(t *Thread, o Value) { v.FloatValue.Assign(t, o); v.store() }
+
+func (v storedFloatV) Set(t *Thread, x float64) { v.FloatValue.Set(t, x); v.store() }
+
+type storedStringV struct {
+	StringValue
+	store func()
+}
+
+func (v storedStringV) Assign(t *Thread, o Value) { v.StringValue.Assign(t, o); v.store() }
+
+func (v storedStringV) Set(t *Thread, x string) { v.StringValue.Set(t, x); v.store() }
+
+type storedStructV struct {
+	StructValue
+	store func()
+}
+
+func (v storedStructV) Assign(t *Thread, o Value) { v.StructValue.Assign(t, o); v.store() }
+
+type storedPtrV struct {
+	PtrValue
+	store func()
+}
+
+func (v storedPtrV) Assign(t *Thread, o Value) { v.PtrValue.Assign(t, o); v.store() }
+
+func (v storedPtrV) Set(t *Thread, x Value) { v.PtrValue.Set(t, x); v.store() }
+
+type storedMapV struct {
+	MapValue
+	store func()
+}
+
+func (v storedMapV) Assign(t *Thread, o Value) { v.MapValue.Assign(t, o); v.store() }
+
+func (v storedMapV) Set(t *Thread, x Map) { v.MapValue.Set(t, x); v.store() }
+
+type storedFuncV struct {
+	FuncValue
+	store func()
+}
+
+func (v storedFuncV) Assign(t *Thread, o Value) { v.FuncValue.Assign(t, o); v.store() }
+
+func (v storedFuncV) Set(t *Thread, x Func) { v.FuncValue.Set(t, x); v.store() }
+
+type storedInterfaceV struct {
+	InterfaceValue
+	store func()
+}
+
+func (v storedInterfaceV) Assign(t *Thread, o Value) { v.InterfaceValue.Assign(t, o); v.store() }
+
+func (v storedInterfaceV) Set(t *Thread, x Interface) { v.InterfaceValue.Set(t, x); v.store() }
+
+type storedArrayV struct {
+	ArrayValue
+	store func()
+}
+
+func (v storedArrayV) Assign(t *Thread, o Value) { v.ArrayValue.Assign(t, o); v.store() }
+
+type storedSliceV struct {
+	SliceValue
+	store func()
+}
+
+func (v storedSliceV) Assign(t *Thread, o Value) { v.SliceValue.Assign(t, o); v.store() }
+
+func (v storedSliceV) Set(t *Thread, x Slice) { v.SliceValue.Set(t, x); v.store() }
+
+type refFuncV struct {
+	rv  reflect.Value
+	typ *FuncType
+}
+
+func (v refFuncV) String() string { return "func {...}" }
+
+func (v refFuncV) Assign(t *Thread, o Value) { v.Set(t, o.(FuncValue).Get(t)) }
+
+func (v refFuncV) Get(*Thread) Func {
+	if v.rv.IsNil() {
+		return nil
+	}
+	return &nativeFunc{nativeCaller(v.rv, v.typ), len(v.typ.In), len(v.typ.Out)}
+}
+
+func (v refFuncV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refFuncV) Set(t *Thread, x Func) {
+	if x == nil {
+		v.rv.Set(reflect.Zero(v.rv.Type()))
+		return
+	}
+	v.rv.Set(nativeFuncValue(t, x, v.typ, v.rv.Type()))
+}
+
+func (v refFuncV) ref() reflect.Value { return v.rv }
+
+type refInterfaceV struct{ rv reflect.Value }
+
+func (v refInterfaceV) String() string { return fmt.Sprint(v.rv.Interface()) }
+
+func (v refInterfaceV) Assign(t *Thread, o Value) { v.Set(t, o.(InterfaceValue).Get(t)) }
+
+func (v refInterfaceV) Get(t *Thread) Interface {
+	return interfaceFromNative(t, v.rv.Interface())
+}
+
+func (v refInterfaceV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refInterfaceV) Set(t *Thread, x Interface) {
+	v.rv.Set(nativeInterface(t, x, v.rv.Type()))
+}
+
+func (v refInterfaceV) ref() reflect.Value { return v.rv }
+
+// A refArrayV is an array backed by a native array, or by the backing
+// array of a native slice.
+type refArrayV struct {
+	rv   reflect.Value
+	elem Type
+}
+
+func (v refArrayV) String() string {
+	res := "{"
+	for i := 0; i < v.rv.Len(); i++ {
+		if i > 0 {
+			res += ", "
+		}
+		res += v.Elem(nil, int64(i)).String()
+	}
+	return res + "}"
+}
+
+func (v refArrayV) Assign(t *Thread, o Value) {
+	oa := o.(ArrayValue)
+	for i := 0; i < v.rv.Len(); i++ {
+		v.Elem(t, int64(i)).Assign(t, oa.Elem(t, int64(i)))
+	}
+}
+
+func (v refArrayV) Get(*Thread) ArrayValue { return v }
+
+func (v refArrayV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refArrayV) Elem(t *Thread, i int64) Value {
+	return refFromNative(v.rv.Index(int(i)), v.elem)
+}
+
+func (v refArrayV) Sub(i int64, len int64) ArrayValue {
+	return refArrayV{v.rv.Slice(int(i), int(i+len)), v.elem}
+}
+
+type refSliceV struct {
+	rv  reflect.Value
+	typ *SliceType
+}
+
+func (v refSliceV) String() string {
+	if v.rv.IsNil() {
+		return "<nil>"
+	}
+	return refArrayV{v.rv, v.typ.Elem}.String()
+}
+
+func (v refSliceV) Assign(t *Thread, o Value) { v.Set(t, o.(SliceValue).Get(t)) }
+
+func (v refSliceV) Get(*Thread) Slice {
+	if v.rv.IsNil() {
+		return Slice{nil, 0, 0}
+	}
+	base := refArrayV{v.rv.Slice(0, v.rv.Cap()), v.typ.Elem}
+	return Slice{base, int64(v.rv.Len()), int64(v.rv.Cap())}
+}
+
+func (v refSliceV) GetNative(*Thread) Thing { return v.rv.Interface() }
+
+func (v refSliceV) Set(t *Thread, x Slice) { v.rv.Set(nativeSlice(t, x, v.rv.Type())) }
+
+func (v refSliceV) ref() reflect.Value { return v.rv }
+
 /*
  * Function bridging
  */
@@ -161,12 +1341,83 @@ type nativeFunc struct {
 	in, out int
 }
 
+// nativeCaller returns the body of an interpreter function of type ft
+// that calls the native function fv.
+func nativeCaller(fv reflect.Value, ft *FuncType) func(*Thread, []Value, []Value) {
+	rt := fv.Type()
+	return func(thread *Thread, in, out []Value) {
+		// Callbacks from the native function continue at the
+		// depth of this call.
+		if thread.nativeDepth == nil {
+			thread.nativeDepth = new(int64)
+		}
+		outer := atomic.SwapInt64(thread.nativeDepth, int64(thread.depth))
+		defer atomic.StoreInt64(thread.nativeDepth, outer)
+		// Script arrays and pointer targets are copied to native
+		// memory, so what native code writes to them is copied
+		// back once it returns.
+		thread.copies = []nativeCopy{}
+		var reflect_in []reflect.Value
+		for index, inv := range in {
+			reflect_in = append(reflect_in, nativeValue(thread, inv, rt.In(index)))
+		}
+		copies := thread.copies
+		thread.copies = nil
+		// The final argument of a variadic function is already
+		// a slice.
+		var reflect_out []reflect.Value
+		if rt.IsVariadic() {
+			reflect_out = fv.CallSlice(reflect_in)
+		} else {
+			reflect_out = fv.Call(reflect_in)
+		}
+		thread.writeBack(copies)
+		for index, outv := range reflect_out {
+			out[index] = valueFromReflect(thread, outv, ft.Out[index])
+		}
+	}
+}
+
+// nativeFuncValue returns a native function of type rt that calls
+// the interpreter function f of type ft on a fresh Thread, under the
+// limits of t.  If f panics, the native function panics with the
+// resulting error.
+func nativeFuncValue(t *Thread, f Func, ft *FuncType, rt reflect.Type) reflect.Value {
+	return reflect.MakeFunc(rt, func(args []reflect.Value) []reflect.Value {
+		thread := t.callback()
+		frame := f.NewFrame()
+		for index, arg := range args {
+			frame.Vars[index] = valueFromReflect(thread, arg, ft.In[index])
+		}
+		for index, t := range ft.Out {
+			frame.Vars[len(ft.In)+index] = t.Zero()
+		}
+		thread.f = frame
+		if err := thread.Try(f.Call); err != nil {
+			panic(err)
+		}
+		results := make([]reflect.Value, len(ft.Out))
+		for index := range ft.Out {
+			results[index] = reflect.New(rt.Out(index)).Elem()
+			results[index].Set(nativeValue(thread, frame.Vars[len(ft.In)+index], rt.Out(index)))
+		}
+		return results
+	})
+}
+
 func (f *nativeFunc) Execute(things... Thing) ([]Thing, error) {
+	return f.execute(&Thread{}, things)
+}
+
+func (f *nativeFunc) ExecuteContext(ctx context.Context, things... Thing) ([]Thing, error) {
+	return f.execute(&Thread{limits: newLimits(ctx, &Spec{}, nil)}, things)
+}
+
+func (f *nativeFunc) execute(thread *Thread, things []Thing) ([]Thing, error) {
 	if len(things) != f.in {
 		return nil, &CallError{fmt.Sprint("Wrong number of arguments. Wanted ", f.in, " but got ", len(things))}
 	}
 	var in []Value
-	thread := &Thread{}
 	for _, t := range things {
 		in = append(in, ValueFromNative(t, thread))
 	}
@@ -191,7 +1442,7 @@ func (f *nativeFunc) Call(t *Thread) { f.fn(t, t.f.Vars[0:f.in], t.f.Vars[f.in:f
 // interpreter Value's.  While somewhat inconvenient, this avoids
 // value marshalling.
 func FuncFromNative(fn func(*Thread, []Value, []Value), t *FuncType) FuncValue {
-	return &funcV{&nativeFunc{fn, len(t.In), len(t.Out)}}
+	return &funcV{&nativeFunc{fn, len(t.In), len(t.Out)}, t}
 }
 
 // FuncFromNativeTyped is like FuncFromNative, but constructs the
@@ -202,3 +1453,113 @@ func FuncFromNativeTyped(fn func(*Thread, []Value, []Value), t interface{}) (*Fu
 	ft := TypeOfNative(t).(*FuncType)
 	return ft, FuncFromNative(fn, ft)
 }
+
+/*
+ * Native packages
+ */
+
+// A NativePackage describes a package of native Go code that scripts
+// can import.  Such descriptions are usually generated by the
+// chicklet-bind command.
+type NativePackage struct {
+	// The name scripts refer to the package by by default.
+	Name string
+	// The package's functions.
+	Funcs map[string]Thing
+	// Pointers to the package's variables, which scripts share
+	// with native code.
+	Vars map[string]Thing
+	// The package's constants.  *big.Int and *big.Rat values are
+	// untyped integer and floating-point constants.
+	Consts map[string]Thing
+	// The package's named types.
+	Types map[string]reflect.Type
+}
+
+// nativePackages is guarded by universe.mu.
+var nativePackages = make(map[string]*NativePackage)
+
+// RegisterPackage makes pkg importable by scripts under path.  A
+// registered package is used in place of the Go source of the package
+// with the same path.  Members whose types cannot be represented in
+// the interpreter are left out.  Registering a path again replaces
+// the package for later imports.
+func RegisterPackage(path string, pkg *NativePackage) {
+	universe.mu.Lock()
+	defer universe.mu.Unlock()
+	nativePackages[path] = pkg
+	delete(universe.pkgs, path)
+}
+
+// importNative adds the scope of the native package registered under
+// path to the universe, and reports whether there is such a package.
+func importNative(path string) bool {
+	universe.mu.Lock()
+	pkg, ok := nativePackages[path]
+	_, imported := universe.pkgs[path]
+	universe.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if imported {
+		return true
+	}
+	s := universe.ChildScope()
+	s.global = true
+	for name, rt := range pkg.Types {
+		convertible(func() { s.defs[name] = TypeFromNative(rt) })
+	}
+	for name, f := range pkg.Funcs {
+		convertible(func() { s.DefineConst(name, token.NoPos, TypeOfNative(f), ValueFromNative(f, &Thread{})) })
+	}
+	for name, c := range pkg.Consts {
+		convertible(func() {
+			switch c := c.(type) {
+			case *big.Int:
+				s.DefineConst(name, token.NoPos, IdealIntType, &idealIntV{c})
+			case *big.Rat:
+				s.DefineConst(name, token.NoPos, IdealFloatType, &idealFloatV{c})
+			default:
+				s.DefineConst(name, token.NoPos, TypeOfNative(c), ValueFromNative(c, &Thread{}))
+			}
+		})
+	}
+	for name, p := range pkg.Vars {
+		rv := reflect.ValueOf(p)
+		if rv.Kind() != reflect.Ptr || rv.IsNil() {
+			continue
+		}
+		convertible(func() {
+			t := TypeFromNative(rv.Type().Elem())
+			init := refFromNative(rv.Elem(), t)
+			v, _ := s.DefineVar(name, token.NoPos, t)
+			v.Init = init
+		})
+	}
+	universe.mu.Lock()
+	current := nativePackages[path] == pkg
+	if current {
+		universe.pkgs[path] = s
+	}
+	universe.mu.Unlock()
+	if !current {
+		// The package was registered again meanwhile.
+		return importNative(path)
+	}
+	return true
+}
+
+// convertible runs f and reports whether it completed without
+// panicking with a ConvertError.
+func convertible(f func()) (ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if _, isConv := e.(*ConvertError); !isConv {
+				panic(e)
+			}
+			ok = false
+		}
+	}()
+	f()
+	return true
+}
@@ -2,10 +2,17 @@
 package chicklet
 
 import (
+	"context"
+	"errors"
+	"go/token"
 	"testing"
 	"math/big"
 	"reflect"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 func TestIntReturn(t *testing.T) {
@@ -143,6 +150,719 @@ func TestEvalFuncEval(t *testing.T) {
 	}
 }
 
+func TestUncaughtPanic(t *testing.T) {
+	c := NewWorld()
+	s := "func() { defer func() {}(); panic(\"boom\") }()"
+	_, err := c.Eval(s)
+	if p, ok := err.(*PanicError); !ok {
+		t.Error(s, "should produce a *PanicError, got", err)
+	} else if p.Value.Value.GetNative(nil) != "boom" {
+		t.Error(s, "should panic with boom, got", p.Value.Value)
+	}
+}
+
+func TestRuntimeErrorPanic(t *testing.T) {
+	c := NewWorld()
+	s := "func() { defer func() {}(); x := []int{}; x[1]++ }()"
+	_, err := c.Eval(s)
+	var ie IndexError
+	if _, ok := err.(*PanicError); !ok {
+		t.Error(s, "should produce a *PanicError, got", err)
+	} else if !errors.As(err, &ie) || ie.Idx != 1 {
+		t.Error(s, "should wrap an IndexError, got", err)
+	}
+}
+
+func TestExecutePanic(t *testing.T) {
+	c := NewWorld()
+	s := "func() { panic(1) }"
+	result := eval(t, c, s)
+	if _, err := result.(Executable).Execute(); err == nil {
+		t.Error(s, "should panic when called")
+	} else if _, ok := err.(*PanicError); !ok {
+		t.Error(s, "should produce a *PanicError, got", err)
+	}
+}
+
+func TestDefineUnsupported(t *testing.T) {
+	c := NewWorld()
+	if err := c.Define("ch", make(chan int)); err == nil {
+		t.Error("defining a chan should fail")
+	} else if _, ok := err.(*ConvertError); !ok {
+		t.Error("defining a chan should produce a *ConvertError, got", err)
+	}
+	if err := c.Define("ch", 1); err != nil {
+		t.Error("failed definition should not define ch, got", err)
+	}
+}
+
+func TestUnsupportedSyntax(t *testing.T) {
+	c := NewWorld()
+	for _, s := range []string{"x := 1i", "x := ~1", "x := nil"} {
+		if _, err := c.Eval(s); err == nil {
+			t.Error(s, "should not compile")
+		} else if _, ok := err.(*InternalError); ok {
+			t.Error(s, "should produce a compile error, got", err)
+		}
+	}
+	evalTest(t, c, "1 + 1 == 2", true)
+}
+
+// A bogusDef is a definition the compiler does not know about.
+type bogusDef struct{}
+
+func (bogusDef) Pos() token.Pos { return token.NoPos }
+
+func TestInternalErrorPosition(t *testing.T) {
+	c := NewWorld()
+	c.scope.defs["bogus"] = bogusDef{}
+	s := "1 + bogus"
+	if _, err := c.Eval(s); err == nil {
+		t.Error(s, "should fail")
+	} else if ie, ok := err.(*InternalError); !ok {
+		t.Error(s, "should produce an *InternalError, got", err)
+	} else if !ie.Pos.IsValid() {
+		t.Error(s, "should report the position of the identifier, got", err)
+	}
+}
+
+func TestNativePackageNilVar(t *testing.T) {
+	RegisterPackage("chicklet/nilvar", &NativePackage{Name: "nilvar", Vars: map[string]Thing{"V": (*int)(nil), "W": &testPkgLevel}})
+	c := NewWorld()
+	eval(t, c, "import \"chicklet/nilvar\"")
+	if _, err := c.Eval("nilvar.V"); err == nil {
+		t.Error("a nil variable pointer should be left out")
+	}
+	eval(t, c, "nilvar.W")
+}
+
+func TestDefineMap(t *testing.T) {
+	c := NewWorld()
+	m := map[string]int{"a": 1, "b": 2}
+	if err := c.Define("m", m); err != nil {
+		t.Fatal("defining a map should work, got", err)
+	}
+	evalTest(t, c, "m[\"a\"] + m[\"b\"]", 3)
+	evalTest(t, c, "len(m)", 2)
+	evalTest(t, c, "func() int { n := 0; for k, v := range m { n += len(k) * v }; return n }()", 3)
+	eval(t, c, "m[\"c\"] = 3")
+	evalTest(t, c, "m", map[string]int{"a": 1, "b": 2, "c": 3})
+	if len(m) != 2 {
+		t.Error("the native map should have been copied, got", m)
+	}
+}
+
+func TestMapReturn(t *testing.T) {
+	evalTestReturn(t, "map[string]int{\"a\": 1}", map[string]int{"a": 1})
+	evalTestReturn(t, "map[int]string{}", map[int]string{})
+	evalTestReturn(t, "func() map[uint8]bool { var m map[uint8]bool; return m }()", map[uint8]bool(nil))
+}
+
+func TestNativeFuncMap(t *testing.T) {
+	nativeFuncCallTest(t, func(m map[string]int) int { return m["x"] }, "map[string]int{\"x\": 7}", 7)
+	nativeFuncCallTest(t, func() map[int]int { return map[int]int{1: 2} }, "", map[int]int{1: 2})
+	c := NewWorld()
+	c.Define("ms", testMapStruct{map[string]int{"x": 5}})
+	c.Define("f", func(s testMapStruct) int { return s.M["x"] + len(s.M) })
+	eval(t, c, "ms.M[\"y\"] = 1")
+	evalTest(t, c, "f(ms)", 7)
+}
+
+func TestNativeMapShared(t *testing.T) {
+	c := NewWorld()
+	ms := &testMapStruct{map[string]int{"x": 5}}
+	c.Define("p", ms)
+	eval(t, c, "p.M[\"y\"] = 1")
+	if ms.M["y"] != 1 {
+		t.Error("setting an entry of a native map should reach the host, got", ms.M)
+	}
+	ms.M["z"] = 2
+	evalTest(t, c, "p.M[\"z\"] + len(p.M)", 5)
+	eval(t, c, "p.M[\"x\"] = p.M[\"x\"] + 2")
+	if ms.M["x"] != 7 {
+		t.Error("updating an entry of a native map should reach the host, got", ms.M)
+	}
+	evalTest(t, c, "func() int { n := 0; for _, v := range p.M { n += v }; return n }()", 10)
+	c.Define("keep", func(m map[string]int) { m["w"] = 4 })
+	eval(t, c, "keep(p.M)")
+	if ms.M["w"] != 4 {
+		t.Error("a native map passed back to native code should be shared, got", ms.M)
+	}
+	ms.M = nil
+	evalTest(t, c, "len(p.M)", 0)
+	eval(t, c, "p.M = map[string]int{\"v\": 6}")
+	if ms.M["v"] != 6 {
+		t.Error("assigning a native map should reach the host, got", ms.M)
+	}
+}
+
+func TestNativeInterfaces(t *testing.T) {
+	c := NewWorld()
+	c.Define("fail", func(s string) error {
+		if s == "" {
+			return nil
+		}
+		return errors.New(s)
+	})
+	c.Define("msg", func(e error) string {
+		if e == nil {
+			return "nil"
+		}
+		return e.Error()
+	})
+	c.Define("id", func(x interface{}) interface{} { return x })
+	c.Define("wrap", func(e error) error { return e })
+	eval(t, c, "type myErr struct { s string }")
+	eval(t, c, "func (e myErr) Error() string { return \"my \" + e.s }")
+	evalTest(t, c, "fail(\"\") == nil", true)
+	evalTest(t, c, "fail(\"boom\").Error()", "boom")
+	evalTest(t, c, "fail(\"boom\").(interface { Error() string }).Error()", "boom")
+	evalTest(t, c, "msg(myErr{\"bad\"})", "my bad")
+	evalTest(t, c, "msg(nil)", "nil")
+	evalTest(t, c, "msg(fail(\"native\"))", "native")
+	evalTest(t, c, "wrap(myErr{\"x\"}).(myErr).s", "x")
+	evalTest(t, c, "func() string { var e error = myErr{\"q\"}; return msg(e) }()", "my q")
+	evalTest(t, c, "id(5).(int) + 1", 6)
+	evalTest(t, c, "id(\"a\")", "a")
+	var kept interface{}
+	c.Define("keep", func(x interface{}) { kept = x })
+	eval(t, c, "type point struct { X int; y string; Tags []string }")
+	eval(t, c, "keep(point{1, \"a\", []string{\"b\"}})")
+	if exp := (struct{ X int; Tags []string }{1, []string{"b"}}); !reflect.DeepEqual(kept, exp) {
+		t.Errorf("a script struct should reach native code as %#v, got %#v", exp, kept)
+	}
+	eval(t, c, "keep([]interface{}{point{2, \"\", []string{}}})")
+	if exp := []interface{}{struct{ X int; Tags []string }{2, []string{}}}; !reflect.DeepEqual(kept, exp) {
+		t.Errorf("a script struct in a slice should reach native code as %#v, got %#v", exp, kept)
+	}
+	result := eval(t, c, "func() error { return myErr{\"e\"} }")
+	if rval, err := result.(Executable).Execute(); err != nil {
+		t.Error("returning an error should work, got", err)
+	} else if e, ok := rval[0].(error); !ok || e.Error() != "my e" {
+		t.Error("a script error should be returned as a native error, got", rval[0])
+	}
+}
+
+func TestConcurrentWorlds(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := NewWorld()
+			c.Define("n", i)
+			c.Define("d", time.Duration(i))
+			c.Define("m", map[string][]int{"a": {i}})
+			c.Define("id", func(x interface{}) interface{} { return x })
+			c.Define("s", testStruct{i, "s"})
+			c.Define("fail", errors.New)
+			eval(t, c, "type point struct { X, Y int }")
+			evalTest(t, c, "int(d) + m[\"a\"][0] + s.I", 3*i)
+			evalTest(t, c, "id(point{n, -n})", struct{ X, Y int }{i, -i})
+			evalTest(t, c, "id(fail(\"e\")).(error).Error()", "e")
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestNativeCallback(t *testing.T) {
+	c := NewWorld()
+	var saved func(string) string
+	var boom func()
+	c.Define("apply", func(f func(int) int, x int) int { return f(x) })
+	c.Define("register", func(f func(string) string) { saved = f })
+	c.Define("registerBoom", func(f func()) { boom = f })
+	evalTest(t, c, "apply(func(x int) int { return x * 2 }, 21)", 42)
+	eval(t, c, "register(func(s string) string { return s + \"!\" })")
+	if saved == nil {
+		t.Fatal("register should receive a native func")
+	}
+	if s := saved("hi"); s != "hi!" {
+		t.Error("the callback should return hi!, got", s)
+	}
+	eval(t, c, "registerBoom(func() { panic(\"boom\") })")
+	func() {
+		defer func() {
+			if _, ok := recover().(*PanicError); !ok {
+				t.Error("a panicking callback should panic with a *PanicError")
+			}
+		}()
+		boom()
+	}()
+	result := eval(t, c, "func() func(int) int { return func(x int) int { return x + 1 } }")
+	if rval, err := result.(Executable).Execute(); err != nil {
+		t.Error("returning a func should work, got", err)
+	} else if f, ok := rval[0].(func(int) int); !ok || f(1) != 2 {
+		t.Error("a returned func should be a native func, got", rval[0])
+	}
+}
+
+func TestDefineAliased(t *testing.T) {
+	c := NewWorld()
+	s := &testStruct{1, "hello"}
+	c.Define("obj", s)
+	eval(t, c, "obj.I = 5")
+	eval(t, c, "obj.S += \" world\"")
+	if s.I != 5 || s.S != "hello world" {
+		t.Error("script changes should reach the native struct, got", s)
+	}
+	s.I = 7
+	evalTest(t, c, "obj.I", 7)
+	evalTest(t, c, "obj", s)
+	eval(t, c, "func() { v := *obj; v.I = 100 }()")
+	if s.I != 7 {
+		t.Error("copies of the native struct should not alias it, got", s)
+	}
+	c.Define("get", func(p *testStruct) int { return p.I })
+	evalTest(t, c, "get(obj)", 7)
+	n := &testNode{I: 1}
+	c.Define("n", n)
+	eval(t, c, "n.Next = n")
+	if n.Next != n {
+		t.Error("assigning a native pointer should alias it, got", n.Next)
+	}
+	evalTest(t, c, "n.Next.Next.I", 1)
+	evalTest(t, c, "n.Next == n", true)
+}
+
+func TestNativeMethods(t *testing.T) {
+	c := NewWorld()
+	cnt := &testCounter{1}
+	c.Define("cnt", cnt)
+	c.Define("val", testCounter{5})
+	evalTest(t, c, "cnt.Add(2)", 3)
+	if cnt.N != 3 {
+		t.Error("a pointer method should change the native value, got", cnt.N)
+	}
+	evalTest(t, c, "cnt.Get()", 3)
+	evalTest(t, c, "val.Get()", 5)
+	evalTest(t, c, "func() int { f := cnt.Get; cnt.Add(1); return f() }()", 3)
+	evalTest(t, c, "func() string { var s interface { String() string } = cnt; return s.String() }()", "counter 4")
+	c.Define("n", testCount(4))
+	evalTest(t, c, "n.Double()", testCount(8))
+	evalTest(t, c, "n.Double().Double() + 1", testCount(17))
+	c.Define("d", 1500*time.Millisecond)
+	evalTest(t, c, "d.String()", "1.5s")
+	evalTest(t, c, "(d * 2).Seconds()", 3.0)
+}
+
+func TestNativeVariadic(t *testing.T) {
+	c := NewWorld()
+	c.Define("sprintf", fmt.Sprintf)
+	c.Define("join", func(sep string, xs ...string) string { return strings.Join(xs, sep) })
+	c.Define("applyv", func(f func(...int) int) int { return f(1, 2, 3) })
+	evalTest(t, c, "sprintf(\"%d-%s\", 1, \"a\")", "1-a")
+	evalTest(t, c, "sprintf(\"none\")", "none")
+	evalTest(t, c, "join(\",\", \"a\", \"b\")", "a,b")
+	evalTest(t, c, "join(\",\", []string{\"c\", \"d\"}...)", "c,d")
+	evalTest(t, c, "applyv(func(xs ...int) int { return len(xs) + xs[2] })", 6)
+}
+
+func TestNativeRoundTrip(t *testing.T) {
+	things := []Thing{
+		true, int(-1), int8(-2), int16(-3), int32(-4), int64(-5),
+		uint(1), uint8(2), uint16(3), uint32(4), uint64(5), uintptr(6),
+		float32(1.5), float64(2.5), "str",
+		[]byte("ab"), []string{"x", "y"}, []int16(nil),
+		map[string]int8{"a": 1}, testStruct{1, "s"}, testBytes{[]byte("b")},
+		[2]int{1, 2}, [2]byte{3, 4}, [0]string{}, testArray{5, 6}, testArrays{[2]int{7, 8}, [][1]string{{"a"}}},
+		&testStruct{2, "p"}, (*int)(nil), [1]*int{new(int)},
+	}
+	for _, x := range things {
+		y := ValueFromNative(x, &Thread{}).GetNative(&Thread{})
+		if reflect.TypeOf(y) != reflect.TypeOf(x) || !reflect.DeepEqual(x, y) {
+			t.Errorf("%v of type %T should round-trip, got %v of type %T", x, x, y, y)
+		}
+	}
+	funcs := []Thing{strings.ToUpper, testArray.Sum, func(xs ...int) [2]int { return [2]int{len(xs), 0} }}
+	for _, f := range funcs {
+		g := ValueFromNative(f, &Thread{}).GetNative(&Thread{})
+		if reflect.TypeOf(g) != reflect.TypeOf(f) {
+			t.Errorf("%T should round-trip, got %T", f, g)
+		}
+	}
+	if g, ok := ValueFromNative(strings.ToUpper, &Thread{}).GetNative(&Thread{}).(func(string) string); !ok || g("a") != "A" {
+		t.Error("a round-tripped function should call the native one")
+	}
+	if g, ok := ValueFromNative(testArray.Sum, &Thread{}).GetNative(&Thread{}).(func(testArray) int); !ok || g(testArray{1, 2}) != 3 {
+		t.Error("a round-tripped function should pass native arrays")
+	}
+	c := NewWorld()
+	c.Define("c", testCelsius(3.5))
+	evalTest(t, c, "c", testCelsius(3.5))
+	evalTest(t, c, "c * 2", testCelsius(7))
+	evalTest(t, c, "uint8(200) + 1", uint8(201))
+	evalTest(t, c, "[]byte(\"hi\")", []byte("hi"))
+	c.Define("a", testArray{1, 2})
+	c.Define("s", testArrays{[2]int{3, 4}, nil})
+	c.Define("id", func(x interface{}) interface{} { return x })
+	evalTest(t, c, "[3]int{1, 2, 3}", [3]int{1, 2, 3})
+	evalTest(t, c, "a", testArray{1, 2})
+	evalTest(t, c, "a.Sum() + s.A[1]", 7)
+	evalTest(t, c, "func() [2]int { x := a; x[0] = 5; return x }()", [2]int{5, 2})
+	evalTest(t, c, "id([2]string{\"x\", \"y\"})", [2]string{"x", "y"})
+}
+
+type testArray [2]int
+
+func (a testArray) Sum() int { return a[0] + a[1] }
+
+type testArrays struct {
+	A [2]int
+	B [][1]string
+}
+
+type testPCounter int
+
+type testTwinA struct{ X int }
+
+type testTwinB struct{ X int }
+
+func TestNativeTypesIsolated(t *testing.T) {
+	c1 := NewWorld()
+	c1.Define("c", testCelsius(3.5))
+	c1.Define("p", testPCounter(1))
+	c1.Define("d", time.Second)
+	c1.Define("a", testTwinA{1})
+	c1.Define("b", testTwinB{2})
+	evalTest(t, c1, "a", testTwinA{1})
+	evalTest(t, c1, "b", testTwinB{2})
+	c2 := NewWorld()
+	evalTest(t, c2, "2.5", big.NewRat(5, 2))
+	evalTest(t, c2, "float64(2.5)", 2.5)
+	evalTest(t, c2, "int(3)", 3)
+	evalTest(t, c2, "int64(3)", int64(3))
+	evalTest(t, c2, "map[string]int{\"a\": 1}", map[string]int{"a": 1})
+	evalTest(t, c2, "[]int{1}", []int{1})
+}
+
+func TestDefaultConstants(t *testing.T) {
+	c := NewWorld()
+	evalTest(t, c, "1 + 2", big.NewInt(3))
+	c.Spec().DefaultConstants = true
+	evalTest(t, c, "1 + 2", 3)
+	evalTest(t, c, "1.5 * 3", 4.5)
+	if _, err := c.Eval("1 << 70"); err == nil {
+		t.Error("1 << 70 should overflow int")
+	}
+}
+
+func TestNativeHiddenFields(t *testing.T) {
+	c := NewWorld()
+	c.Define("h", testHidden{"n", 42, 1, "skip"})
+	c.Define("secret", func(x testHidden) int { return x.secret })
+	evalTest(t, c, "h.Name", "n")
+	evalTest(t, c, "h.renamed", 1)
+	for _, s := range []string{"h.secret", "h.Skip", "h.Alias"} {
+		if _, err := c.Eval(s); err == nil {
+			t.Error(s, "should not compile")
+		}
+	}
+	eval(t, c, "h.Name = \"m\"")
+	evalTest(t, c, "h", testHidden{"m", 42, 1, "skip"})
+	evalTest(t, c, "secret(h)", 42)
+	p := &testHidden{"p", 7, 2, ""}
+	c.Define("p", p)
+	eval(t, c, "p.renamed = 5")
+	if p.Alias != 5 {
+		t.Error("a renamed field should alias the native field, got", p.Alias)
+	}
+	eval(t, c, "*p = h")
+	if *p != (testHidden{"m", 42, 1, "skip"}) {
+		t.Error("assigning through a native pointer should copy hidden fields, got", *p)
+	}
+	if err := c.Define("bad", testBadTag{}); err == nil {
+		t.Error("an invalid chicklet tag should fail")
+	}
+}
+
+func TestNativeSlices(t *testing.T) {
+	c := NewWorld()
+	samples := []float64{1, 2, 3}
+	c.Define("xs", samples)
+	c.Define("scale", func(s []float64) {
+		for i := range s {
+			s[i] *= 2
+		}
+	})
+	eval(t, c, "xs[0] = 10")
+	if samples[0] != 10 {
+		t.Error("script writes should reach the native slice, got", samples)
+	}
+	eval(t, c, "scale(xs[1:])")
+	evalTest(t, c, "xs[1] + xs[2]", 10.0)
+	evalTest(t, c, "cap(xs[1:2])", 2)
+	r := eval(t, c, "xs[1:]")
+	if rs, ok := r.([]float64); !ok || len(rs) != 2 {
+		t.Error("xs[1:] should be a native []float64, got", r)
+	} else if rs[0] = 99; samples[1] != 99 {
+		t.Error("the returned slice should share the native array, got", samples)
+	}
+	room := make([]int, 2, 4)
+	c.Define("room", room)
+	evalTest(t, c, "append(room, 5)", []int{0, 0, 5})
+	if room[:3][2] != 5 {
+		t.Error("append within capacity should reuse the native array, got", room[:3])
+	}
+	evalTest(t, c, "append(room, 1, 2, 3)", []int{0, 0, 1, 2, 3})
+	if room[:3][2] != 5 {
+		t.Error("append beyond capacity should not write the native array, got", room[:3])
+	}
+	c.Define("fill", func(b []byte) int { return copy(b, "hi") })
+	c.Define("sortSlice", sort.Slice)
+	c.Define("fillp", func(p *int) { *p = 7 })
+	evalTest(t, c, "func() string { b := make([]byte, 2); fill(b); return string(b) }()", "hi")
+	evalTest(t, c, "func() byte { b := make([]byte, 4); fill(b[2:]); return b[3] }()", uint8('i'))
+	evalTest(t, c, "func() []int { s := []int{3, 1, 2}; sortSlice(s, func(i, j int) bool { return s[i] < s[j] }); return s }()", []int{1, 2, 3})
+	evalTest(t, c, "func() string { var a [2]byte; fill(a[:]); return string(a[:]) }()", "hi")
+	evalTest(t, c, "func() int { x := 0; fillp(&x); return x }()", 7)
+	evalTest(t, c, "func() int { var b struct { N int; s []int }; fillp(&b.N); return b.N }()", 7)
+}
+
+var testPkgLevel = 3
+
+func TestNativePackage(t *testing.T) {
+	testPkgLevel = 3
+	RegisterPackage("chicklet/testpkg", &NativePackage{
+		Name: "testpkg",
+		Funcs: map[string]Thing{
+			"Upper": strings.ToUpper,
+			"Join":  strings.Join,
+			"Bump":  func(c *testCounter) int { return c.Add(1) },
+			"Pipe":  func(ch chan int) {},
+		},
+		Vars:   map[string]Thing{"Level": &testPkgLevel},
+		Consts: map[string]Thing{"Big": big.NewInt(1 << 40), "Half": big.NewRat(1, 2), "Name": "test"},
+		Types:  map[string]reflect.Type{"Counter": reflect.TypeOf(testCounter{})},
+	})
+	c := NewWorld()
+	eval(t, c, "import \"chicklet/testpkg\"")
+	evalTest(t, c, "testpkg.Upper(\"abc\")", "ABC")
+	evalTest(t, c, "testpkg.Join([]string{\"a\", \"b\"}, testpkg.Name)", "atestb")
+	evalTest(t, c, "testpkg.Big >> 38", big.NewInt(4))
+	evalTest(t, c, "testpkg.Half * 4", big.NewRat(2, 1))
+	evalTest(t, c, "testpkg.Level", 3)
+	eval(t, c, "testpkg.Level = 7")
+	if testPkgLevel != 7 {
+		t.Error("script writes should reach the native variable, got", testPkgLevel)
+	}
+	if _, err := c.Eval("testpkg.Pipe"); err == nil {
+		t.Error("functions with unsupported types should be left out")
+	}
+	evalTest(t, c, "func() int { var n testpkg.Counter; return testpkg.Bump(&n) + testpkg.Bump(&n) }()", 3)
+	evalTest(t, c, "func() int { n := testpkg.Counter{N: 4}; return n.Get() }()", 4)
+	evalTest(t, c, "func() int { p := &testpkg.Counter{N: 1}; p.Add(2); return p.N }()", 3)
+	for _, s := range []string{"var n testpkg.Upper", "testpkg.Counter", "var n testpkg.counter"} {
+		if _, err := c.Eval(s); err == nil {
+			t.Error(s, "should not compile")
+		}
+	}
+	c2 := NewWorld()
+	eval(t, c2, "import p \"chicklet/testpkg\"")
+	evalTest(t, c2, "p.Upper(\"x\")", "X")
+	RegisterPackage("chicklet/testpkg", &NativePackage{Name: "testpkg", Funcs: map[string]Thing{"Upper": strings.ToLower}})
+	c3 := NewWorld()
+	eval(t, c3, "import \"chicklet/testpkg\"")
+	evalTest(t, c3, "testpkg.Upper(\"X\")", "x")
+	evalTest(t, c2, "p.Upper(\"x\")", "X")
+}
+
+func TestRegisterPackageConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := fmt.Sprintf("chicklet/concurrent%d", i%2)
+			RegisterPackage(path, &NativePackage{Name: "p", Funcs: map[string]Thing{"Upper": strings.ToUpper}})
+			c := NewWorld()
+			eval(t, c, "import \""+path+"\"")
+			evalTest(t, c, "p.Upper(\"a\")", "A")
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestStepBudget(t *testing.T) {
+	c := NewWorld()
+	c.Spec().MaxSteps = 1000
+	_, err := c.Eval("for {}")
+	if be, ok := err.(*BudgetError); !ok || be.Steps != 1000 || !errors.Is(err, ErrBudgetExceeded) {
+		t.Error("for {} should exceed the budget, got", err)
+	}
+	evalTest(t, c, "func() int { s := 0; for i := 0; i < 10; i++ { s += i }; return s }()", 45)
+	r := eval(t, c, "func() { for {} }")
+	if _, err := r.(Executable).Execute(); !errors.Is(err, ErrBudgetExceeded) {
+		t.Error("executing a looping function should exceed the budget, got", err)
+	}
+	c.Define("each", func(f func()) {
+		for {
+			f()
+		}
+	})
+	if _, err := c.Eval("func() { n := 0; defer func() { recover() }(); each(func() { n++ }) }()"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Error("callbacks should count against the budget and not be recoverable, got", err)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	c := NewWorld()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.EvalContext(ctx, "for {}"); err != context.DeadlineExceeded {
+		t.Error("for {} should run until the deadline, got", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.EvalContext(ctx, "func() { ch := make(chan int); <-ch }()"); err != context.DeadlineExceeded {
+		t.Error("a blocked receive should end at the deadline, got", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.EvalContext(ctx, "func() { defer func() { recover() }(); select {} }()"); err != context.DeadlineExceeded {
+		t.Error("cancellation should not be recoverable, got", err)
+	}
+	r := eval(t, c, "func(n int) int { for n > 0 {}; return n }")
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.(Executable).ExecuteContext(ctx, 1); err != context.Canceled {
+		t.Error("executing under a cancelled context should fail, got", err)
+	}
+	if rval, err := r.(Executable).ExecuteContext(context.Background(), 0); err != nil || rval[0] != 0 {
+		t.Error("executing under a live context should succeed, got", rval, err)
+	}
+	code, err := c.Compile(defaultFileSet, "x := 1; for { x++ }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := code.RunContext(ctx); err != context.Canceled {
+		t.Error("running under a cancelled context should fail, got", err)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	for _, s := range []string{
+		"make([]int, 1<<40)",
+		"make(map[int]int, 1<<40)",
+		"make(chan [1024]int, 1<<20)",
+		"new([1<<30]byte)",
+		"[1<<20]int{}",
+		"func() { s := []int{}; for { s = append(s, 1) } }()",
+		"func() { m := map[int]string{}; for i := 0; ; i++ { m[i] = \"\" } }()",
+		"func() { s := \"x\"; for { s += s } }()",
+		"func() { var a [1<<25]int; a[0] = 1 }()",
+		"var a [1<<25]int",
+		"new([1<<61][16]byte)",
+		"func() { b := make([]byte, 1<<18); var keep []string; for i := 0; i < 100; i++ { keep = append(keep, string(b)) } }()",
+		"func() { r := make([]rune, 1<<16); var keep []string; for i := 0; i < 100; i++ { keep = append(keep, string(r)) } }()",
+	} {
+		c := NewWorld()
+		c.Spec().MaxMemory = 1 << 20
+		_, err := c.Eval(s)
+		if me, ok := err.(*MemoryError); !ok || me.Limit != 1<<20 || !errors.Is(err, ErrMemoryLimit) {
+			t.Error(s, "should exceed the memory limit, got", err)
+		}
+	}
+	c := NewWorld()
+	c.Spec().MaxMemory = 1 << 20
+	if _, err := c.Eval("make([]int, 1<<20)"); !errors.Is(err, ErrMemoryLimit) {
+		t.Error("make([]int, 1<<20) should exceed the memory limit, got", err)
+	}
+	evalTest(t, c, "len(make([]int, 1000))", 1000)
+	if _, err := c.Eval("func() int { defer func() { recover() }(); return len(make([]byte, 1<<21)) }()"); !errors.Is(err, ErrMemoryLimit) {
+		t.Error("exceeding the memory limit should not be recoverable, got", err)
+	}
+	// The limit applies to the World, not to each run.
+	eval(t, c, "var s []int")
+	for i := 0; ; i++ {
+		_, err := c.Eval("s = append(s, make([]int, 1<<12)...)")
+		if errors.Is(err, ErrMemoryLimit) {
+			break
+		}
+		if err != nil || i == 100 {
+			t.Fatal("growing a World variable should exhaust the memory limit, got", err)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	RegisterPackage("chicklet/allowed", &NativePackage{Name: "allowed", Funcs: map[string]Thing{"Upper": strings.ToUpper}})
+	RegisterPackage("chicklet/denied", &NativePackage{Name: "denied", Funcs: map[string]Thing{"Upper": strings.ToUpper}})
+	c := NewWorld()
+	c.Spec().Policy = Policy{
+		AllowImports:     []string{"chicklet/allowed", "chicklet/denied"},
+		DenyImports:      []string{"chicklet/denied"},
+		DenyBuiltins:     []string{"print", "println"},
+		NoGoroutines:     true,
+		NoUnboundedLoops: true,
+	}
+	eval(t, c, "import \"chicklet/allowed\"")
+	evalTest(t, c, "allowed.Upper(\"a\")", "A")
+	for _, s := range []string{
+		"import \"chicklet/denied\"",
+		"import \"fmt\"",
+		"// a comment hides the import from the prefix match\nimport \"chicklet/denied\"",
+		"println(1)",
+		"func() { print(\"x\") }",
+		"go func() {}()",
+		"for {}",
+		"for true {}",
+		"const forever = true; for !!forever {}",
+		"for 1 < 2 {}",
+		"for range make(chan int) {}",
+		"func() { L: goto L }",
+	} {
+		if _, err := c.Eval(s); err == nil {
+			t.Error(s, "should not be allowed")
+		}
+	}
+	evalTest(t, c, "func() int { n := 0; for n < 3 { n++ }; goto L; L: return n }()", 3)
+	evalTest(t, c, "func() (n int) { defer func() { recover(); n = 2 }(); panic(1) }()", 2)
+	eval(t, c, "func print(s string) string { return s }")
+	evalTest(t, c, "print(\"shadowed\")", "shadowed")
+}
+
+func TestStackOverflow(t *testing.T) {
+	c := NewWorld()
+	eval(t, c, "func down(n int) int { if n == 0 { return 0 }; return 1 + down(n-1) }")
+	eval(t, c, "func forever() { forever() }")
+	if _, err := c.Eval("forever()"); !reflect.DeepEqual(err, &StackOverflowError{DefaultMaxCallDepth}) {
+		t.Error("infinite recursion should overflow the default depth, got", err)
+	}
+	evalTest(t, c, "down(1000)", 1000)
+	c.Spec().MaxCallDepth = 100
+	evalTest(t, c, "down(99)", 99)
+	if _, err := c.Eval("down(100)"); !reflect.DeepEqual(err, &StackOverflowError{100}) {
+		t.Error("down(100) should overflow a depth of 100, got", err)
+	}
+	if _, err := c.Eval("func() { defer func() { recover() }(); forever() }()"); !reflect.DeepEqual(err, &StackOverflowError{100}) {
+		t.Error("a stack overflow should not be recoverable, got", err)
+	}
+	r := eval(t, c, "func() { forever() }")
+	if _, err := r.(Executable).Execute(); !reflect.DeepEqual(err, &StackOverflowError{100}) {
+		t.Error("executing a recursing function should overflow, got", err)
+	}
+	c.Spec().MaxCallDepth = 50
+	c.Define("apply", func(f func(int) int, n int) int { return f(n) })
+	eval(t, c, "func viaApply(n int) int { if n == 0 { return 0 }; return 1 + apply(viaApply, n-1) }")
+	evalTest(t, c, "viaApply(20)", 20)
+	if _, err := c.Eval("viaApply(100)"); !reflect.DeepEqual(err, &StackOverflowError{50}) {
+		t.Error("recursion through native code should overflow, got", err)
+	}
+	var stored func(int) int
+	c.Define("store", func(f func(int) int) { stored = f })
+	c.Define("callStored", func(n int) int { return stored(n) })
+	eval(t, c, "func viaStored(n int) int { if n == 0 { return 0 }; return 1 + callStored(n-1) }")
+	eval(t, c, "store(viaStored)")
+	evalTest(t, c, "viaStored(20)", 20)
+	if _, err := c.Eval("viaStored(200)"); !reflect.DeepEqual(err, &StackOverflowError{50}) {
+		t.Error("recursion through a stored callback should overflow, got", err)
+	}
+	evalTest(t, c, "viaStored(20)", 20)
+}
+
 func defineTest(t *testing.T, value Thing) {
  	c := NewWorld()
 	c.Define("testDef", value)
@@ -193,7 +913,7 @@ func evalTest(t *testing.T, c *World, s string, exp Thing) {
 }
 
 func checkEquality(t *testing.T, s string, exp, val Thing) {
-	if exp != val && !reflect.DeepEqual(exp, val) {
+	if !reflect.DeepEqual(exp, val) && exp != val {
 		t.Error(fmt.Sprintf("%s should generate %v of type %T but generated %v of type %T\n", 
 			s, exp, exp, val, val))
 	}
@@ -212,4 +932,47 @@ type testStruct struct {
 type testStruct2 struct {
 	F float64
 	I int
-}
\ No newline at end of file
+}
+
+type testNode struct {
+	I    int
+	Next *testNode
+}
+
+type testCounter struct {
+	N int
+}
+
+func (c testCounter) Get() int { return c.N }
+
+func (c *testCounter) Add(d int) int {
+	c.N += d
+	return c.N
+}
+
+func (c testCounter) String() string { return fmt.Sprint("counter ", c.N) }
+
+type testCelsius float64
+
+type testCount int
+
+func (c testCount) Double() testCount { return c * 2 }
+
+type testBytes struct {
+	B []byte
+}
+
+type testHidden struct {
+	Name   string
+	secret int
+	Alias  int    `chicklet:"renamed"`
+	Skip   string `chicklet:"-"`
+}
+
+type testBadTag struct {
+	A int `chicklet:"not valid"`
+}
+
+type testMapStruct struct {
+	M map[string]int
+}
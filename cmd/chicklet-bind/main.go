@@ -0,0 +1,204 @@
+// Command chicklet-bind generates a Go file that registers a native
+// package with chicklet, so that scripts importing it call compiled
+// code instead of interpreting the package's source.
+//
+// Usage:
+//
+//	chicklet-bind [-o file] [-package name] importpath
+//
+// The generated file exposes the exported functions, variables,
+// constants and types of the package.  Generic functions and types,
+// and complex constants, are left out.  Link it into the program
+// that runs the scripts.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/constant"
+	"go/format"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"math/big"
+	"os"
+	pathpkg "path"
+)
+
+var (
+	output  = flag.String("o", "", "write the generated file to `file` instead of standard output")
+	pkgName = flag.String("package", "main", "the package `name` of the generated file")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: chicklet-bind [-o file] [-package name] importpath\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+	src, err := generate(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "chicklet-bind:", err)
+		os.Exit(1)
+	}
+	if *output == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := ioutil.WriteFile(*output, src, 0666); err != nil {
+		fmt.Fprintln(os.Stderr, "chicklet-bind:", err)
+		os.Exit(1)
+	}
+}
+
+// generate returns the formatted registration file for the package
+// with the given import path.
+func generate(path string) ([]byte, error) {
+	// Type-check the package from source, so that no compiled
+	// export data is needed.
+	pkg, err := importer.ForCompiler(token.NewFileSet(), "source", nil).Import(path)
+	if err != nil {
+		return nil, err
+	}
+	if pkg.Name() == "main" {
+		return nil, fmt.Errorf("%s is a command, not an importable package", path)
+	}
+
+	// Refer to the package by its own name, unless that clashes
+	// with the other imports of the generated file.
+	qual := pkg.Name()
+	switch qual {
+	case "big", "reflect", "chicklet":
+		qual = "native" + qual
+	}
+
+	g := &generator{qual: qual}
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *types.Func:
+			if obj.Type().(*types.Signature).TypeParams().Len() > 0 {
+				continue
+			}
+			g.funcs = append(g.funcs, entry{name, qual + "." + name})
+		case *types.Var:
+			g.vars = append(g.vars, entry{name, "&" + qual + "." + name})
+		case *types.Const:
+			if value, ok := g.constValue(obj); ok {
+				g.consts = append(g.consts, entry{name, value})
+			}
+		case *types.TypeName:
+			if named, ok := obj.Type().(*types.Named); ok && named.TypeParams().Len() > 0 {
+				continue
+			}
+			if obj.IsAlias() {
+				continue
+			}
+			g.reflect = true
+			g.types = append(g.types, entry{name, fmt.Sprintf("reflect.TypeOf((*%s.%s)(nil)).Elem()", qual, name)})
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by chicklet-bind %s; DO NOT EDIT.\n\n", path)
+	fmt.Fprintf(&buf, "package %s\n\n", *pkgName)
+	fmt.Fprintf(&buf, "import (\n")
+	if g.big {
+		fmt.Fprintf(&buf, "\t\"math/big\"\n")
+	}
+	if g.reflect {
+		fmt.Fprintf(&buf, "\t\"reflect\"\n")
+	}
+	fmt.Fprintf(&buf, "\n\t\"github.com/zond/chicklet\"\n")
+	if qual == pathpkg.Base(path) {
+		fmt.Fprintf(&buf, "\t%q\n", path)
+	} else {
+		fmt.Fprintf(&buf, "\t%s %q\n", qual, path)
+	}
+	fmt.Fprintf(&buf, ")\n\n")
+	fmt.Fprintf(&buf, "func init() {\n")
+	fmt.Fprintf(&buf, "\tchicklet.RegisterPackage(%q, &chicklet.NativePackage{\n", path)
+	fmt.Fprintf(&buf, "\t\tName: %q,\n", pkg.Name())
+	writeMap(&buf, "Funcs", "chicklet.Thing", g.funcs)
+	writeMap(&buf, "Vars", "chicklet.Thing", g.vars)
+	writeMap(&buf, "Consts", "chicklet.Thing", g.consts)
+	writeMap(&buf, "Types", "reflect.Type", g.types)
+	fmt.Fprintf(&buf, "\t})\n")
+	fmt.Fprintf(&buf, "}\n")
+	return format.Source(buf.Bytes())
+}
+
+// An entry is a member of the generated package description: its
+// name and the Go expression for its value.
+type entry struct {
+	name, value string
+}
+
+type generator struct {
+	qual                       string
+	funcs, vars, consts, types []entry
+	// Whether the generated file uses math/big and reflect.
+	big, reflect bool
+}
+
+// constValue returns the expression for the constant c, if it can be
+// represented.  Untyped numeric constants become *big.Int and *big.Rat
+// values, which keeps their precision.
+func (g *generator) constValue(c *types.Const) (string, bool) {
+	basic, ok := c.Type().(*types.Basic)
+	if !ok || basic.Info()&types.IsUntyped == 0 {
+		return g.qual + "." + c.Name(), true
+	}
+	val := c.Val()
+	switch val.Kind() {
+	case constant.Bool, constant.String:
+		return g.qual + "." + c.Name(), true
+	case constant.Int:
+		// Untyped runes default to rune, like in Go.
+		if basic.Kind() == types.UntypedRune {
+			return g.qual + "." + c.Name(), true
+		}
+		g.big = true
+		if i, exact := constant.Int64Val(val); exact {
+			return fmt.Sprintf("big.NewInt(%d)", i), true
+		}
+		return fmt.Sprintf("func() *big.Int { i, _ := new(big.Int).SetString(%q, 10); return i }()", val.ExactString()), true
+	case constant.Float:
+		g.big = true
+		r, ok := new(big.Rat).SetString(val.ExactString())
+		if !ok {
+			return "", false
+		}
+		if r.Num().IsInt64() && r.Denom().IsInt64() {
+			return fmt.Sprintf("big.NewRat(%d, %d)", r.Num().Int64(), r.Denom().Int64()), true
+		}
+		return fmt.Sprintf("func() *big.Rat { r, _ := new(big.Rat).SetString(%q); return r }()", r.String()), true
+	}
+	// Complex constants have no interpreter equivalent.
+	return "", false
+}
+
+// writeMap writes the field of the package description holding
+// entries, if there are any.  The scope lists them sorted by name.
+func writeMap(buf *bytes.Buffer, field, elem string, entries []entry) {
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "\t\t%s: map[string]%s{\n", field, elem)
+	for _, e := range entries {
+		fmt.Fprintf(buf, "\t\t\t%q: %s,\n", e.name, e.value)
+	}
+	fmt.Fprintf(buf, "\t\t},\n")
+}
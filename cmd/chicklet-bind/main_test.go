@@ -0,0 +1,64 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+// contains reports whether the generated file src contains want,
+// ignoring differences in white space.
+func contains(src []byte, want string) bool {
+	return strings.Contains(strings.Join(strings.Fields(string(src)), " "), want)
+}
+
+func TestGenerate(t *testing.T) {
+	src, err := generate("math/big")
+	if err != nil {
+		t.Fatal("generating bindings for math/big should work, got", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "big.go", src, 0); err != nil {
+		t.Fatal("the generated file should parse, got", err)
+	}
+	for _, want := range []string{
+		"// Code generated by chicklet-bind math/big; DO NOT EDIT.",
+		"package main",
+		"nativebig \"math/big\"",
+		"chicklet.RegisterPackage(\"math/big\", &chicklet.NativePackage{",
+		"Name: \"big\",",
+		"\"NewInt\": nativebig.NewInt,",
+		"\"MaxBase\": nativebig.MaxBase,",
+		"\"Int\": reflect.TypeOf((*nativebig.Int)(nil)).Elem(),",
+	} {
+		if !contains(src, want) {
+			t.Errorf("the generated file should contain %q, got\n%s", want, src)
+		}
+	}
+	if strings.Contains(string(src), "\"newInt\"") {
+		t.Error("unexported members should be left out")
+	}
+}
+
+func TestGenerateConstants(t *testing.T) {
+	src, err := generate("math")
+	if err != nil {
+		t.Fatal("generating bindings for math should work, got", err)
+	}
+	for _, want := range []string{
+		"\"MaxInt8\": big.NewInt(127),",
+		"\"MaxUint64\": func() *big.Int { i, _ := new(big.Int).SetString(\"18446744073709551615\", 10); return i }(),",
+		"\"Sqrt\": math.Sqrt,",
+		"\"github.com/zond/chicklet\" \"math\" )",
+	} {
+		if !contains(src, want) {
+			t.Errorf("the generated file should contain %q, got\n%s", want, src)
+		}
+	}
+}
+
+func TestGenerateCommand(t *testing.T) {
+	if _, err := generate("cmd/gofmt"); err == nil || !strings.Contains(err.Error(), "command") {
+		t.Error("generating bindings for a command should fail, got", err)
+	}
+}
@@ -20,6 +20,11 @@ type compiler struct {
 	errors       *scanner.ErrorList
 	numErrors    int
 	silentErrors int
+	// The position of the node most recently entered, used to
+	// locate internal errors.
+	curPos token.Pos
+	// The Spec whose Policy the compiled code must obey.
+	spec *Spec
 }
 
 func (a *compiler) diagAt(pos token.Pos, format string, args ...interface{}) {
@@ -29,9 +34,18 @@ func (a *compiler) diagAt(pos token.Pos, format string, args ...interface{}) {
 
 func (a *compiler) numError() int { return a.numErrors + a.silentErrors }
 
+// recoverInternal must be deferred by entry points into the compiler.
+// It converts a panic raised while compiling into an InternalError
+// at the position of the node being compiled, which it stores in err.
+func (a *compiler) recoverInternal(err *error) {
+	if r := recover(); r != nil {
+		*err = &InternalError{a.fset.Position(a.curPos), fmt.Sprint(r)}
+	}
+}
+
 // The universal scope
 func newUniverse() *universeScope {
-	sc := &universeScope{&Scope{nil, 0}, make(map[string]*Scope)}
+	sc := &universeScope{Scope: &Scope{nil, 0}, pkgs: make(map[string]*Scope)}
 	sc.block = &block{
 		offset: 0,
 		scope:  sc.Scope,
@@ -5,14 +5,15 @@
 package chicklet
 
 import (
-	"errors"
 	"fmt"
 	"go/ast"
 	"go/token"
 	"log"
 	"math/big"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 var (
@@ -33,6 +34,11 @@ type expr struct {
 	// for which we need to know the Map and key.
 	evalMapValue func(t *Thread) (Map, interface{})
 
+	// Receive expressions and type assertions permit the
+	// "v, ok = x" form of assignment, for which we need to know
+	// whether the receive or assertion succeeded.
+	evalCommaOk func(t *Thread) (Value, bool)
+
 	// Evaluate to the "address of" this value; that is, the
 	// settable Value object.  nil for expressions whose address
 	// cannot be taken.
@@ -42,6 +48,12 @@ type expr struct {
 	// that are valid expression statements should set this.
 	exec func(t *Thread)
 
+	// Evaluate the function value and arguments of this call
+	// without performing it, returning the function and the
+	// frame to call it in.  Only calls of non-built-in functions
+	// set this.
+	evalCall func(t *Thread) (Func, *Frame)
+
 	// If this expression is a type, this is its compiled type.
 	// This is only permitted in the function position of a call
 	// expression.  In this case, t should be nil.
@@ -149,12 +161,109 @@ func (a *expr) convertTo(t Type) *expr {
 	case *idealFloatType:
 		res.eval = func() *big.Rat { return rat }
 	default:
-		log.Panicf("cannot convert to type %T", t)
+		a.diag("cannot use %v as %v", a.t, t)
+		return nil
 	}
 
 	return res
 }
 
+// convertNil converts the nil expression a to a new analyzed
+// expression with the zero value of type t, which must be a pointer,
+// function, slice, map, channel, or interface type.
+func (a *expr) convertNil(t Type) *expr {
+	res := a.newExpr(t, a.desc)
+	switch t.lit().(type) {
+	case *PtrType:
+		res.eval = func(*Thread) Value { return nil }
+	case *FuncType:
+		res.eval = func(*Thread) Func { return nil }
+	case *SliceType:
+		res.eval = func(*Thread) Slice { return Slice{} }
+	case *MapType:
+		res.eval = func(*Thread) Map { return nil }
+	case *InterfaceType:
+		res.eval = func(*Thread) Interface { return Interface{} }
+	case *ChanType:
+		res.eval = func(*Thread) Chan { return nil }
+	default:
+		a.diag("cannot use nil as %v", t)
+		return nil
+	}
+	return res
+}
+
+// convertToInterface converts the value of the analyzed expression a
+// to a new analyzed expression of interface type it, or produces an
+// error if the type of a does not implement it.  Unless a is itself
+// an interface value, the dynamic type of the result is the type of
+// a.  Ideal constants are first converted to their default type.
+func (a *expr) convertToInterface(it Type) *expr {
+	if m, ok := it.lit().(*InterfaceType).implementedBy(a.t); !ok {
+		a.diag("%v does not implement %v (missing method %s)", a.t, it, m.Name)
+		return nil
+	}
+	if _, ok := a.t.lit().(*InterfaceType); ok {
+		if it.compat(a.t, false) {
+			return a
+		}
+		rf := a.asIface()
+		res := a.newExpr(it, a.desc)
+		res.eval = func(t *Thread) Interface { return rf(t) }
+		return res
+	}
+	switch a.t {
+	case IdealIntType:
+		a = a.convertTo(IntType)
+	case IdealFloatType:
+		a = a.convertTo(Float64Type)
+	}
+	if a == nil {
+		return nil
+	}
+
+	vt := a.t
+	assign := genAssign(vt, a)
+	res := a.newExpr(it, a.desc)
+	res.eval = func(t *Thread) Interface {
+		v := vt.Zero()
+		assign(v, t)
+		return Interface{vt, v}
+	}
+	return res
+}
+
+// convertForInterface converts the analyzed expression a for
+// assignment to a variable of interface type it, as convertToInterface
+// does, but also accepts nil and values that already have a
+// compatible type.
+func (a *expr) convertForInterface(it Type) *expr {
+	switch {
+	case a.t == NilType:
+		return a.convertNil(it)
+	case it.compat(a.t, false):
+		return a
+	}
+	return a.convertToInterface(it)
+}
+
+// asMapKey returns a function that evaluates a as a key of a map.
+// Interface values are keyed by their dynamic type and value.
+func (a *expr) asMapKey() func(*Thread) interface{} {
+	if _, ok := a.t.lit().(*InterfaceType); ok {
+		f := a.asIface()
+		return func(t *Thread) interface{} { return interfaceMapKey(t, f(t)) }
+	}
+	return a.asInterface()
+}
+
+// isEmptyInterface returns true if t is an interface type without
+// methods.
+func isEmptyInterface(t Type) bool {
+	it, ok := t.lit().(*InterfaceType)
+	return ok && len(it.methods) == 0
+}
+
 // convertToInt converts this expression to an integer, if possible,
 // or produces an error if not.  This accepts ideal ints, uints, and
 // ints.  If max is not -1, produces an error if possible if the value
@@ -335,13 +444,28 @@ func (a *expr) asValue() func(t *Thread) Value {
 		}
 	case *PtrType:
 		fct = func(t *Thread) Value {
-			return a.asPtr()(t)
+			return &ptrV{a.asPtr()(t), ty}
+		}
+	case *InterfaceType:
+		fct = func(t *Thread) Value {
+			return &interfaceV{a.asIface()(t)}
+		}
+	case *ChanType:
+		fct = func(t *Thread) Value {
+			return &chanV{a.asChan()(t)}
+		}
+	case *FuncType:
+		fct = func(t *Thread) Value {
+			return &funcV{a.asFunc()(t), ty}
+		}
+	case *SliceType:
+		fct = func(t *Thread) Value {
+			return &sliceV{a.asSlice()(t), ty}
+		}
+	case *MapType:
+		fct = func(t *Thread) Value {
+			return &mapV{a.asMap()(t), ty}
 		}
-		//case *FuncType:
-		//case *InterfaceType:
-		//case *SliceType:
-		//case *MapType:
-		//case *ChanType:
 		//case *NamedType:
 		//case *MultiType:
 	case *packageType:
@@ -389,6 +513,9 @@ type assignCompiler struct {
 	allowMap bool
 	// Whether this is a "r, ok = a[x]" assignment.
 	isMapUnpack bool
+	// Whether this is a "r, ok = <-c" or "r, ok = x.(T)"
+	// assignment.
+	isCommaOkUnpack bool
 	// The operation name to use in error messages, such as
 	// "assignment" or "function call".
 	errOp string
@@ -452,6 +579,13 @@ func (a *assignCompiler) allowMapForms(nls int) {
 		a.rmt = NewMultiType([]Type{a.rs[0].t, BoolType})
 		a.isMapUnpack = true
 	}
+
+	// Likewise for r, ok = <-c and r, ok = x.(T)
+	if nls == 2 && len(a.rs) == 1 && a.rs[0] != nil && a.rs[0].evalCommaOk != nil {
+		a.isUnpack = true
+		a.rmt = NewMultiType([]Type{a.rs[0].t, BoolType})
+		a.isCommaOkUnpack = true
+	}
 }
 
 // compile type checks and compiles an assignment operation, returning
@@ -510,6 +644,13 @@ func (a *assignCompiler) compile(b *block, lt Type) func(Value, *Thread) {
 				}
 				t.f.Vars[tempIdx] = multiV([]Value{v, &found})
 			}
+		} else if a.isCommaOkUnpack {
+			rf := a.rs[0].evalCommaOk
+			effect = func(t *Thread) {
+				v, ok := rf(t)
+				found := boolV(ok)
+				t.f.Vars[tempIdx] = multiV([]Value{v, &found})
+			}
 		} else {
 			rf := a.rs[0].asMulti()
 			effect = func(t *Thread) { t.f.Vars[tempIdx] = multiV(rf(t)) }
@@ -538,7 +679,8 @@ func (a *assignCompiler) compile(b *block, lt Type) func(Value, *Thread) {
 		// When [an ideal is] (used in an expression) assigned
 		// to a variable or typed constant, the destination
 		// must be able to represent the assigned value.
-		if rt.isIdeal() {
+		_, isIface := lt.lit().(*InterfaceType)
+		if rt.isIdeal() && !isIface {
 			a.rs[i] = a.rs[i].convertTo(lmt.Elems[i])
 			if a.rs[i] == nil {
 				bad = true
@@ -547,6 +689,28 @@ func (a *assignCompiler) compile(b *block, lt Type) func(Value, *Thread) {
 			rt = a.rs[i].t
 		}
 
+		// nil can be assigned to any pointer, function, slice,
+		// map, or interface variable.
+		if rt == NilType {
+			a.rs[i] = a.rs[i].convertNil(lt)
+			if a.rs[i] == nil {
+				bad = true
+				continue
+			}
+			rt = a.rs[i].t
+		}
+
+		// A value can be assigned to a variable of interface
+		// type if its type implements the interface.
+		if isIface && !lt.compat(rt, false) {
+			a.rs[i] = a.rs[i].convertToInterface(lt)
+			if a.rs[i] == nil {
+				bad = true
+				continue
+			}
+			rt = a.rs[i].t
+		}
+
 		// A pointer p to an array can be assigned to a slice
 		// variable v with compatible element type if the type
 		// of p or v is unnamed.
@@ -564,6 +728,18 @@ func (a *assignCompiler) compile(b *block, lt Type) func(Value, *Thread) {
 			}
 		}
 
+		// A bidirectional channel can be assigned to a
+		// channel variable with identical element type if the
+		// type of the channel or the variable is unnamed.
+		if rct, ok := rt.lit().(*ChanType); ok && rct.Dir == ast.SEND|ast.RECV {
+			if lct, ok := lt.lit().(*ChanType); ok && lct.Elem.compat(rct.Elem, false) && (rt.lit() == Type(rt) || lt.lit() == Type(lt)) {
+				rf := a.rs[i].asChan()
+				a.rs[i] = a.rs[i].newExpr(lt, a.rs[i].desc)
+				a.rs[i].eval = func(t *Thread) Chan { return rf(t) }
+				rt = a.rs[i].t
+			}
+		}
+
 		if !lt.compat(rt, false) {
 			if len(a.rs) == 1 {
 				a.rs[0].diag("illegal operand types for %s\n\t%v\n\t%v", a.errOp, lt, rt)
@@ -630,6 +806,7 @@ type exprCompiler struct {
 // otherwise result in errors).
 func (a *exprCompiler) compile(x ast.Expr, callCtx bool) *expr {
 	ei := &exprInfo{a.compiler, x.Pos()}
+	a.curPos = x.Pos()
 
 	switch x := x.(type) {
 	// Literals
@@ -644,7 +821,7 @@ func (a *exprCompiler) compile(x ast.Expr, callCtx bool) *expr {
 		case token.STRING:
 			return ei.compileStringLit(string(x.Value))
 		default:
-			log.Panicf("unexpected basic literal type %v", x.Kind)
+			goto notimpl
 		}
 
 	case *ast.CompositeLit:
@@ -674,7 +851,7 @@ func (a *exprCompiler) compile(x ast.Expr, callCtx bool) *expr {
 				switch kk := kv.Key.(type) {
 				case *ast.Ident:
 					switch x.Type.(type) {
-					case *ast.Ident:
+					case *ast.Ident, *ast.SelectorExpr:
 						keys = append(keys, kk.Name)
 					default:
 						keys = append(keys, a.compile(kv.Key, callCtx))
@@ -763,18 +940,34 @@ func (a *exprCompiler) compile(x ast.Expr, callCtx bool) *expr {
 		if bad || l == nil {
 			return nil
 		}
+		if l.valType != nil {
+			switch {
+			case len(args) == 0:
+				a.diagAt(x.Rparen, "missing argument to conversion to %v", l.valType)
+				return nil
+			case len(args) > 1:
+				a.diagAt(args[1].pos, "too many arguments to conversion to %v", l.valType)
+				return nil
+			}
+			return ei.compileConversion(l.valType, args[0])
+		}
 		if a.constant {
 			a.diagAt(x.Pos(), "function call in constant context")
 			return nil
 		}
 
-		if l.valType != nil {
-			a.diagAt(x.Pos(), "type conversions not implemented")
-			return nil
-		} else if ft, ok := l.t.(*FuncType); ok && ft.builtin != "" {
+		spread := x.Ellipsis.IsValid()
+		if ft, ok := l.t.(*FuncType); ok && ft.builtin != "" {
+			switch {
+			case spread && ft == appendType:
+				return ei.compileAppendSlice(args)
+			case spread:
+				a.diagAt(x.Ellipsis, "invalid use of ... with %v", ft)
+				return nil
+			}
 			return ei.compileBuiltinCallExpr(a.block, ft, args)
 		} else {
-			return ei.compileCallExpr(a.block, l, args)
+			return ei.compileCallExpr(a.block, l, args, spread)
 		}
 
 	case *ast.Ident:
@@ -828,10 +1021,22 @@ func (a *exprCompiler) compile(x ast.Expr, callCtx bool) *expr {
 		return a.compile(x.X, callCtx)
 
 	case *ast.SelectorExpr:
-		v := a.compile(x.X, false)
+		// Types of imported packages are used like other types
+		if t, ok := a.block.LookupType(x); ok && t != nil {
+			if !callCtx {
+				ei.diag("type %s.%s used as expression", x.X, x.Sel.Name)
+				return nil
+			}
+			return ei.exprFromType(t)
+		}
+		// This could be a method expression, so allow types
+		v := a.compile(x.X, true)
 		if v == nil {
 			return nil
 		}
+		if v.valType != nil {
+			return ei.compileMethodExpr(v.valType, x.Sel.Name)
+		}
 		return ei.compileSelectorExpr(v, x.Sel.Name)
 
 	case *ast.StarExpr:
@@ -851,7 +1056,16 @@ func (a *exprCompiler) compile(x ast.Expr, callCtx bool) *expr {
 		goto notimpl
 
 	case *ast.TypeAssertExpr:
-		goto notimpl
+		if x.Type == nil {
+			a.diagAt(x.Pos(), "use of .(type) outside type switch")
+			return nil
+		}
+		v := a.compile(x.X, false)
+		at := a.compileType(a.block, x.Type)
+		if v == nil || at == nil {
+			return nil
+		}
+		return ei.compileTypeAssertExpr(v, at)
 
 	case *ast.UnaryExpr:
 		v := a.compile(x.X, false)
@@ -860,8 +1074,7 @@ func (a *exprCompiler) compile(x ast.Expr, callCtx bool) *expr {
 		}
 		return ei.compileUnaryExpr(x.Op, v)
 	}
-	log.Panicf("unexpected ast node type %T", x)
-	panic("unreachable")
+	goto notimpl
 
 typeexpr:
 	if !callCtx {
@@ -900,6 +1113,10 @@ func (a *exprInfo) compileIdent(b *block, constant bool, callCtx bool, name stri
 				a.diag("built-in function %s cannot be used as a value", ft.builtin)
 				return nil
 			}
+			if contains(a.spec.Policy.DenyBuiltins, ft.builtin) {
+				a.diag("built-in function %s is not allowed", ft.builtin)
+				return nil
+			}
 			// Otherwise, we leave the evaluators empty
 			// because this is handled specially
 		} else {
@@ -945,12 +1162,15 @@ func (a *exprInfo) compileGlobalVariable(v *Variable) *expr {
 		a.silentErrors++
 		return nil
 	}
-	if v.Init == nil {
-		v.Init = v.Type.Zero()
-	}
+	// Variables declared by scripts are initialized when their
+	// declaration runs.
 	expr := a.newExpr(v.Type, "variable")
-	val := v.Init
-	expr.genValue(func(t *Thread) Value { return val })
+	expr.genValue(func(t *Thread) Value {
+		if v.Init == nil {
+			v.Init = v.Type.Zero()
+		}
+		return v.Init
+	})
 	return expr
 }
 
@@ -983,7 +1203,8 @@ func (a *exprInfo) compileCharLit(lit string) *expr {
 func (a *exprInfo) compileFloatLit(lit string) *expr {
 	f, ok := new(big.Rat).SetString(lit)
 	if !ok {
-		log.Panicf("malformed float literal %s at %v passed parser", lit, a.pos)
+		a.diag("malformed float literal %s", lit)
+		return nil
 	}
 	expr := a.newExpr(IdealFloatType, "float literal")
 	expr.eval = func() *big.Rat { return f }
@@ -1028,6 +1249,10 @@ func (a *exprInfo) compileCompositeLit(c *expr, ikeys []interface{}, vals []*exp
 		a.diag("invalid composite expression")
 		return nil
 	}
+	if c.valType == nil {
+		a.diag("%s is not a type", c.desc)
+		return nil
+	}
 	for i, elmt := range vals {
 		if elmt == nil {
 			a.diag("nil argument (#%d)", i+1)
@@ -1035,10 +1260,24 @@ func (a *exprInfo) compileCompositeLit(c *expr, ikeys []interface{}, vals []*exp
 		}
 	}
 
+	// helper function to convert elements stored in interfaces
+	cnv_iface := func(ty Type, elt *expr) *expr {
+		if elt.t == NilType {
+			return elt.convertNil(ty)
+		}
+		return elt.convertToInterface(ty)
+	}
+
 	// helper function to handle literals
 	massage_lit_ideal := func(ty Type, elts []*expr) bool {
 		ok := true
-		if !ty.isIdeal() {
+		if _, isIface := ty.lit().(*InterfaceType); isIface {
+			for i := 0; i < len(elts); i++ {
+				if elts[i] = cnv_iface(ty, elts[i]); elts[i] == nil {
+					return false
+				}
+			}
+		} else if !ty.isIdeal() {
 			for i := 0; i < len(elts); i++ {
 				if elts[i].t.isIdeal() {
 					elt := elts[i].convertTo(ty)
@@ -1081,7 +1320,15 @@ func (a *exprInfo) compileCompositeLit(c *expr, ikeys []interface{}, vals []*exp
 		}
 		chk_and_apply_cnv := func(elts []*expr) bool {
 			for i := 0; i < sz; i++ {
-				if !ty.Elems[i].Type.isIdeal() && elts[i].t.isIdeal() {
+				if _, isIface := ty.Elems[i].Type.lit().(*InterfaceType); isIface {
+					if elts[i] = cnv_iface(ty.Elems[i].Type, elts[i]); elts[i] == nil {
+						return false
+					}
+				} else if elts[i].t == NilType {
+					if elts[i] = elts[i].convertNil(ty.Elems[i].Type); elts[i] == nil {
+						return false
+					}
+				} else if !ty.Elems[i].Type.isIdeal() && elts[i].t.isIdeal() {
 					elt := elts[i].convertTo(ty.Elems[i].Type)
 					if elt == nil {
 						a.diag("cannot convert literal #%d (type %s) to type %s",
@@ -1133,6 +1380,7 @@ func (a *exprInfo) compileCompositeLit(c *expr, ikeys []interface{}, vals []*exp
 			}
 			elts = tmp
 			eval_fct = func(t *Thread) Value {
+				t.alloc(ty, 1)
 				out := ty.Zero().(StructValue)
 				for i := 0; i < sz; i++ {
 					out.Field(t, i).Assign(t, elts[i].asValue()(t))
@@ -1144,6 +1392,7 @@ func (a *exprInfo) compileCompositeLit(c *expr, ikeys []interface{}, vals []*exp
 				return nil
 			}
 			eval_fct = func(t *Thread) Value {
+				t.alloc(ty, 1)
 				out := ty.Zero().(StructValue)
 				for i := 0; i < sz; i++ {
 					out.Field(t, i).Assign(t, elts[i].asValue()(t))
@@ -1163,6 +1412,7 @@ func (a *exprInfo) compileCompositeLit(c *expr, ikeys []interface{}, vals []*exp
 			return nil
 		}
 		eval_fct := func(t *Thread) Value {
+			t.alloc(ty, 1)
 			base := ty.Zero().(ArrayValue)
 			for i := 0; i < sz; i++ {
 				base.Elem(t, int64(i)).Assign(t, elts[i].asValue()(t))
@@ -1176,13 +1426,13 @@ func (a *exprInfo) compileCompositeLit(c *expr, ikeys []interface{}, vals []*exp
 		if !massage_lit_ideal(ty.Elem, elts) {
 			return nil
 		}
-		arrt := NewArrayType(int64(sz), ty.Elem)
 		eval_fct := func(t *Thread) Value {
-			base := arrt.Zero().(ArrayValue)
+			t.alloc(ty.Elem, int64(sz))
+			base := newArray(ty.Elem, int64(sz))
 			for i := 0; i < sz; i++ {
 				base.Elem(t, int64(i)).Assign(t, elts[i].asValue()(t))
 			}
-			return &sliceV{Slice{base, int64(sz), int64(sz)}}
+			return &sliceV{Slice{base, int64(sz), int64(sz)}, ty}
 		}
 		comp.genValue(eval_fct)
 
@@ -1206,9 +1456,11 @@ func (a *exprInfo) compileCompositeLit(c *expr, ikeys []interface{}, vals []*exp
 			return nil
 		}
 		eval_fct := func(t *Thread) Value {
+			t.alloc(ty.Key, int64(sz))
+			t.alloc(ty.Elem, int64(sz))
 			m := evalMap{}
 			for i := 0; i < sz; i++ {
-				k := keys[i].asInterface()
+				k := keys[i].asMapKey()
 				v := elts[i].asValue()
 				m.SetElem(t, k(t), v(t))
 			}
@@ -1284,14 +1536,29 @@ func (a *exprInfo) compileSelectorExpr(v *expr, name string) *expr {
 
 		// If it's a named type, look for methods
 		if ti, ok := t.(*NamedType); ok {
-			_, ok := ti.methods[name]
+			m, ok := ti.methods[name]
 			if ok {
 				mark(depth, pathName+"."+name)
-				log.Panic("Methods not implemented")
+				return func(parent *expr) *expr {
+					return a.compileMethodValue(parent, deref, m)
+				}
 			}
 			t = ti.Def
 		}
 
+		// If it's an interface type, look for methods
+		if ti, ok := t.(*InterfaceType); ok && !deref {
+			for _, m := range ti.methods {
+				if m.Name == name {
+					mark(depth, pathName+"."+name)
+					mt := m.Type
+					return func(parent *expr) *expr {
+						return a.compileInterfaceMethod(parent, name, mt)
+					}
+				}
+			}
+		}
+
 		// If it's a struct type, check fields and embedded types
 		var builder func(*expr) *expr
 		if t, ok := t.(*StructType); ok {
@@ -1346,6 +1613,15 @@ func (a *exprInfo) compileSelectorExpr(v *expr, name string) *expr {
 				// builder for accessing this field.
 				ft := f.Type
 				index := i
+				if ft.isIdeal() {
+					c := f.Const
+					builder = func(parent *expr) *expr {
+						expr := a.newExpr(ft, "selector expression")
+						expr.genConstant(c)
+						return sub(expr)
+					}
+					continue
+				}
 				builder = func(parent *expr) *expr {
 					if deref {
 						parent = a.compileStarExpr(parent)
@@ -1374,6 +1650,99 @@ func (a *exprInfo) compileSelectorExpr(v *expr, name string) *expr {
 	return builder(v)
 }
 
+// compileMethodValue compiles the selection of method m from v, which
+// must be of m's named type, or a pointer to it if deref is true.  The
+// receiver is evaluated and bound when the method value is.
+func (a *exprInfo) compileMethodValue(v *expr, deref bool, m *Method) *expr {
+	recv := v
+	switch {
+	case m.ptrRecv() && !deref:
+		// A pointer method of an addressable value is
+		// shorthand for a call on its address.
+		if v.evalAddr == nil {
+			a.diag("cannot call pointer method %s on %v", m.decl.Name.Name, v.t)
+			return nil
+		}
+		recv = a.compileUnaryExpr(token.AND, v)
+	case !m.ptrRecv() && deref:
+		recv = a.compileStarExpr(v)
+	}
+	if recv == nil {
+		return nil
+	}
+
+	rt := m.recv
+	assign := genAssign(rt, recv)
+	expr := a.newExpr(m.decl.Type, "method value")
+	expr.eval = func(t *Thread) Func {
+		r := rt.Zero()
+		assign(r, t)
+		return &boundMethod{m, r}
+	}
+	return expr
+}
+
+// compileMethodExpr compiles the method expression t.name, which
+// yields a function taking the receiver as its first argument.
+func (a *exprInfo) compileMethodExpr(t Type, name string) *expr {
+	nt, _ := t.(*NamedType)
+	isPtr := false
+	if pt, ok := t.(*PtrType); ok {
+		nt, _ = pt.Elem.(*NamedType)
+		isPtr = true
+	}
+	var m *Method
+	if nt != nil {
+		m = nt.methods[name]
+	}
+	if m == nil {
+		a.diag("type %v has no method %s", t, name)
+		return nil
+	}
+	if m.ptrRecv() && !isPtr {
+		a.diag("invalid method expression %v.%s (needs pointer receiver: (*%v).%s)", t, name, t, name)
+		return nil
+	}
+
+	mt := m.decl.Type
+	in := make([]Type, len(mt.In)+1)
+	in[0] = t
+	copy(in[1:], mt.In)
+	expr := a.newExpr(NewFuncType(in, mt.Variadic, mt.Out), "method expression")
+	if isPtr && !m.ptrRecv() {
+		expr.eval = func(*Thread) Func { return &derefMethod{m} }
+	} else {
+		// m.fn is not set until the method body has been
+		// compiled, so look it up when evaluated.
+		expr.eval = func(*Thread) Func { return m.fn }
+	}
+	return expr
+}
+
+// compileInterfaceMethod compiles the selection of the method name,
+// of type mt, from the interface value v.  The method is looked up in
+// the method set of v's dynamic type when the method value is
+// evaluated.
+func (a *exprInfo) compileInterfaceMethod(v *expr, name string, mt *FuncType) *expr {
+	vf := v.asIface()
+	expr := a.newExpr(mt, "method value")
+	expr.eval = func(t *Thread) Func {
+		i := vf(t)
+		if i.Type == nil {
+			t.Panic(NilPointerError{})
+		}
+		if ot, ok := i.Type.(*opaqueType); ok {
+			return ot.method(i.Value, name, mt)
+		}
+		m, recv := findMethod(i.Type, name)
+		if m == nil {
+			t.Panic(TypeAssertionError{v.t, i.Type, v.t, name})
+		}
+		return &boundMethod{m, recv(t, i.Value)}
+	}
+	return expr
+}
+
 func (a *exprInfo) compileSliceExpr(arr, lo, hi *expr) *expr {
 	// Type check object
 	arr = arr.derefArray()
@@ -1419,7 +1788,7 @@ func (a *exprInfo) compileSliceExpr(arr, lo, hi *expr) *expr {
 		expr.eval = func(t *Thread) Slice {
 			arr, lo, hi := arrf(t), lof(t), hif(t)
 			if lo > hi || hi > bound || lo < 0 {
-				t.Abort(SliceError{lo, hi, bound})
+				t.Panic(SliceError{lo, hi, bound})
 			}
 			return Slice{arr.Sub(lo, bound-lo), hi - lo, bound - lo}
 		}
@@ -1429,7 +1798,7 @@ func (a *exprInfo) compileSliceExpr(arr, lo, hi *expr) *expr {
 		expr.eval = func(t *Thread) Slice {
 			arr, lo, hi := arrf(t), lof(t), hif(t)
 			if lo > hi || hi > arr.Cap || lo < 0 {
-				t.Abort(SliceError{lo, hi, arr.Cap})
+				t.Panic(SliceError{lo, hi, arr.Cap})
 			}
 			return Slice{arr.Base.Sub(lo, arr.Cap-lo), hi - lo, arr.Cap - lo}
 		}
@@ -1442,7 +1811,7 @@ func (a *exprInfo) compileSliceExpr(arr, lo, hi *expr) *expr {
 		expr.eval = func(t *Thread) string {
 			arr, lo, hi := arrf(t), lof(t), hif(t)
 			if lo > hi || hi > int64(len(arr)) || lo < 0 {
-				t.Abort(SliceError{lo, hi, int64(len(arr))})
+				t.Panic(SliceError{lo, hi, int64(len(arr))})
 			}
 			return arr[lo:hi]
 		}
@@ -1483,7 +1852,12 @@ func (a *exprInfo) compileIndexExpr(l, r *expr) *expr {
 
 	case *MapType:
 		at = lt.Elem
-		if r.t.isIdeal() {
+		if _, ok := lt.Key.lit().(*InterfaceType); ok {
+			r = r.convertForInterface(lt.Key)
+			if r == nil {
+				return nil
+			}
+		} else if r.t.isIdeal() {
 			r = r.convertTo(lt.Key)
 			if r == nil {
 				return nil
@@ -1521,7 +1895,7 @@ func (a *exprInfo) compileIndexExpr(l, r *expr) *expr {
 		expr.genValue(func(t *Thread) Value {
 			l, r := lf(t), rf(t)
 			if r < 0 || r >= bound {
-				t.Abort(IndexError{r, bound})
+				t.Panic(IndexError{r, bound})
 			}
 			return l.Elem(t, r)
 		})
@@ -1532,10 +1906,10 @@ func (a *exprInfo) compileIndexExpr(l, r *expr) *expr {
 		expr.genValue(func(t *Thread) Value {
 			l, r := lf(t), rf(t)
 			if l.Base == nil {
-				t.Abort(NilPointerError{})
+				t.Panic(NilPointerError{})
 			}
 			if r < 0 || r >= l.Len {
-				t.Abort(IndexError{r, l.Len})
+				t.Panic(IndexError{r, l.Len})
 			}
 			return l.Base.Elem(t, r)
 		})
@@ -1548,23 +1922,23 @@ func (a *exprInfo) compileIndexExpr(l, r *expr) *expr {
 		expr.eval = func(t *Thread) uint64 {
 			l, r := lf(t), rf(t)
 			if r < 0 || r >= int64(len(l)) {
-				t.Abort(IndexError{r, int64(len(l))})
+				t.Panic(IndexError{r, int64(len(l))})
 			}
 			return uint64(l[r])
 		}
 
 	case *MapType:
 		lf := l.asMap()
-		rf := r.asInterface()
+		rf := r.asMapKey()
 		expr.genValue(func(t *Thread) Value {
 			m := lf(t)
 			k := rf(t)
 			if m == nil {
-				t.Abort(NilPointerError{})
+				t.Panic(NilPointerError{})
 			}
 			e := m.Elem(t, k)
 			if e == nil {
-				t.Abort(KeyError{k})
+				t.Panic(KeyError{k})
 			}
 			return e
 		})
@@ -1583,9 +1957,10 @@ func (a *exprInfo) compileIndexExpr(l, r *expr) *expr {
 	return expr
 }
 
-func (a *exprInfo) compileCallExpr(b *block, l *expr, as []*expr) *expr {
-	// TODO(austin) Variadic functions.
-
+// compileCallExpr compiles a call of l with the arguments as.  If
+// spread is true, the final argument was followed by "..." and is
+// passed as the variadic parameter itself.
+func (a *exprInfo) compileCallExpr(b *block, l *expr, as []*expr, spread bool) *expr {
 	// Type check
 
 	// XXX(Spec) Calling a named function type is okay.  I really
@@ -1599,14 +1974,44 @@ func (a *exprInfo) compileCallExpr(b *block, l *expr, as []*expr) *expr {
 		a.diag("cannot call non-function type %v", l.t)
 		return nil
 	}
+	if spread && !lt.Variadic {
+		a.diag("invalid use of ... in call to non-variadic function %v", l.t)
+		return nil
+	}
+
+	// Arguments for the ...T parameter of a variadic function are
+	// each assigned to an element of a new []T, unless the call
+	// passes the slice itself with "...".
+	nin := len(lt.In)
+	ats := lt.In
+	nfixed, nextra := nin, 0
+	var extrat *ArrayType
+	if lt.Variadic && !spread {
+		nfixed = nin - 1
+		nargs := len(as)
+		if len(as) == 1 {
+			if mt, ok := as[0].t.(*MultiType); ok {
+				nargs = len(mt.Elems)
+			}
+		}
+		if nargs > nfixed {
+			nextra = nargs - nfixed
+		}
+		elem := lt.In[nfixed].lit().(*SliceType).Elem
+		ats = make([]Type, nfixed+nextra)
+		copy(ats, lt.In[:nfixed])
+		for i := nfixed; i < len(ats); i++ {
+			ats[i] = elem
+		}
+		extrat = NewArrayType(int64(nextra), elem)
+	}
 
 	// The arguments must be single-valued expressions assignment
 	// compatible with the parameters of F.
 	//
 	// XXX(Spec) The spec is wrong.  It can also be a single
 	// multi-valued expression.
-	nin := len(lt.In)
-	assign := a.compileAssign(a.pos, b, NewMultiType(lt.In), as, "function call", "argument")
+	assign := a.compileAssign(a.pos, b, NewMultiType(ats), as, "function call", "argument")
 	if assign == nil {
 		return nil
 	}
@@ -1630,13 +2035,26 @@ func (a *exprInfo) compileCallExpr(b *block, l *expr, as []*expr) *expr {
 
 	// Compile
 	lf := l.asFunc()
-	call := func(t *Thread) []Value {
+	setup := func(t *Thread) (Func, *Frame) {
 		fun := lf(t)
 		fr := fun.NewFrame()
 		for i, t := range vts {
 			fr.Vars[i] = t.Zero()
 		}
-		assign(multiV(fr.Vars[0:nin]), t)
+		if nextra == 0 {
+			assign(multiV(fr.Vars[0:len(ats)]), t)
+			return fun, fr
+		}
+		extra := extrat.Zero().(*arrayV)
+		args := make(multiV, len(ats))
+		copy(args, fr.Vars[0:nfixed])
+		copy(args[nfixed:], *extra)
+		assign(args, t)
+		fr.Vars[nfixed].(SliceValue).Set(t, Slice{extra, int64(nextra), int64(nextra)})
+		return fun, fr
+	}
+	call := func(t *Thread) []Value {
+		fun, fr := setup(t)
 		oldf := t.f
 		t.f = fr
 		fun.Call(t)
@@ -1644,10 +2062,304 @@ func (a *exprInfo) compileCallExpr(b *block, l *expr, as []*expr) *expr {
 		return fr.Vars[nin : nin+nout]
 	}
 	expr.genFuncCall(call)
+	expr.evalCall = setup
+
+	return expr
+}
+
+// compileAppendSlice compiles append(s, x...), which appends the
+// elements of the slice x, or the bytes of the string x if s is a
+// []byte.
+func (a *exprInfo) compileAppendSlice(as []*expr) *expr {
+	if len(as) != 2 {
+		a.diag("can only use ... with final argument to 'append'")
+		return nil
+	}
+	arg := as[0].derefArray()
+	st, ok := arg.t.lit().(*SliceType)
+	if !ok {
+		a.diag("illegal argument type for 'append' function\n\t%v", arg.t)
+		return nil
+	}
+	elmty := st.Elem
+	src := as[1].derefArray()
+	var srcf func(t *Thread) (int64, func(int64) Value)
+	switch srct := src.t.lit().(type) {
+	case *SliceType:
+		if !srct.Elem.compat(elmty, false) {
+			a.diag("cannot use %v (type %v) as type %v in 'append'", as[1].desc, src.t, arg.t)
+			return nil
+		}
+		sf := src.asSlice()
+		srcf = func(t *Thread) (int64, func(int64) Value) {
+			s := sf(t)
+			return s.Len, func(i int64) Value { return s.Base.Elem(t, i) }
+		}
+	case *stringType:
+		if ut, ok := elmty.lit().(*uintType); !ok || ut.Bits != 8 {
+			a.diag("cannot use %v (type %v) as type %v in 'append'", as[1].desc, src.t, arg.t)
+			return nil
+		}
+		sf := src.asString()
+		srcf = func(t *Thread) (int64, func(int64) Value) {
+			s := sf(t)
+			return int64(len(s)), func(i int64) Value {
+				b := uint8V(s[i])
+				return &b
+			}
+		}
+	default:
+		a.diag("cannot use %v (type %v) as type %v in 'append'", as[1].desc, src.t, arg.t)
+		return nil
+	}
+	expr := a.newExpr(NewSliceType(elmty), "function call")
+	inval := arg.asSlice()
+	expr.eval = func(t *Thread) Slice {
+		dst := inval(t)
+		n, elem := srcf(t)
+		// Copy the source first, since it may share dst's
+		// backing array.
+		vals := make([]Value, n)
+		for i := range vals {
+			vals[i] = elmty.Zero()
+			vals[i].Assign(t, elem(int64(i)))
+		}
+		res := growSlice(t, dst, n, elmty)
+		for i, val := range vals {
+			res.Base.Elem(t, dst.Len+int64(i)).Assign(t, val)
+		}
+		return res
+	}
+	return expr
+}
+
+// compileConversion compiles the conversion of v to type t.
+func (a *exprInfo) compileConversion(t Type, v *expr) *expr {
+	bad := func() *expr {
+		a.diag("cannot convert %s (type %v) to type %v", v.desc, v.t, t)
+		return nil
+	}
+
+	// Ideal numbers are converted as if assigned to a variable of
+	// type t, except that ideal integers may also become strings.
+	if v.t.isIdeal() {
+		switch t.lit().(type) {
+		case *stringType:
+			if v.t != IdealIntType {
+				return bad()
+			}
+			i := v.asIdealInt()()
+			r := utf8.RuneError
+			if i.Sign() >= 0 && i.Cmp(big.NewInt(utf8.MaxRune)) <= 0 {
+				r = rune(i.Int64())
+			}
+			s := string(r)
+			expr := a.newExpr(t, "conversion")
+			expr.eval = func(*Thread) string { return s }
+			return expr
+		case *InterfaceType:
+			return v.convertToInterface(t)
+		}
+		if !t.isInteger() && !t.isFloat() {
+			return bad()
+		}
+		return v.convertTo(t)
+	}
+
+	if v.t == NilType {
+		return v.convertNil(t)
+	}
+	if _, ok := t.lit().(*InterfaceType); ok {
+		return v.convertToInterface(t)
+	}
+
+	// byteOrRune returns whether t is a slice of bytes or of runes.
+	byteOrRune := func(t Type) (isBytes, isRunes bool) {
+		if st, ok := t.lit().(*SliceType); ok {
+			switch et := st.Elem.lit().(type) {
+			case *uintType:
+				return et.Bits == 8, false
+			case *intType:
+				return false, et.Bits == 32
+			}
+		}
+		return false, false
+	}
+
+	expr := a.newExpr(t, "conversion")
+	switch lt := t.lit().(type) {
+	case *intType, *uintType, *floatType:
+		// Numeric conversions
+		var geti func(*Thread) int64
+		var getu func(*Thread) uint64
+		var getf func(*Thread) float64
+		switch v.t.lit().(type) {
+		case *intType:
+			vf := v.asInt()
+			geti = vf
+			getu = func(t *Thread) uint64 { return uint64(vf(t)) }
+			getf = func(t *Thread) float64 { return float64(vf(t)) }
+		case *uintType:
+			vf := v.asUint()
+			geti = func(t *Thread) int64 { return int64(vf(t)) }
+			getu = vf
+			getf = func(t *Thread) float64 { return float64(vf(t)) }
+		case *floatType:
+			vf := v.asFloat()
+			geti = func(t *Thread) int64 { return int64(vf(t)) }
+			getu = func(t *Thread) uint64 { return uint64(vf(t)) }
+			getf = vf
+		default:
+			return bad()
+		}
+		switch lt := lt.(type) {
+		case *intType:
+			bits := lt.Bits
+			expr.eval = func(t *Thread) int64 { return truncInt(bits, geti(t)) }
+		case *uintType:
+			bits, ptr := lt.Bits, lt.Ptr
+			expr.eval = func(t *Thread) uint64 { return truncUint(bits, ptr, getu(t)) }
+		case *floatType:
+			bits := lt.Bits
+			expr.eval = func(t *Thread) float64 { return truncFloat(bits, getf(t)) }
+		}
 
+	case *stringType:
+		isBytes, isRunes := byteOrRune(v.t)
+		switch v.t.lit().(type) {
+		case *intType:
+			vf := v.asInt()
+			expr.eval = func(t *Thread) string { return string(intToRune(vf(t))) }
+		case *uintType:
+			vf := v.asUint()
+			expr.eval = func(t *Thread) string { return string(intToRune(int64(vf(t)))) }
+		case *SliceType:
+			vf := v.asSlice()
+			switch {
+			case isBytes:
+				expr.eval = func(t *Thread) string {
+					s := vf(t)
+					t.alloc(Uint8Type, s.Len)
+					b := make([]byte, s.Len)
+					for i := range b {
+						b[i] = byte(s.Base.Elem(t, int64(i)).(UintValue).Get(t))
+					}
+					return string(b)
+				}
+			case isRunes:
+				expr.eval = func(t *Thread) string {
+					s := vf(t)
+					// A rune takes up to 4 bytes in a string.
+					t.alloc(Int32Type, s.Len)
+					r := make([]rune, s.Len)
+					for i := range r {
+						r[i] = rune(s.Base.Elem(t, int64(i)).(IntValue).Get(t))
+					}
+					return string(r)
+				}
+			default:
+				return bad()
+			}
+		}
+
+	case *SliceType:
+		if _, ok := v.t.lit().(*stringType); !ok {
+			break
+		}
+		isBytes, isRunes := byteOrRune(t)
+		vf := v.asString()
+		elem := lt.Elem
+		switch {
+		case isBytes:
+			expr.eval = func(t *Thread) Slice {
+				b := []byte(vf(t))
+				n := int64(len(b))
+				t.alloc(elem, n)
+				arr := newArray(elem, n)
+				for i, c := range b {
+					arr.Elem(t, int64(i)).(UintValue).Set(t, uint64(c))
+				}
+				return Slice{arr, n, n}
+			}
+		case isRunes:
+			expr.eval = func(t *Thread) Slice {
+				r := []rune(vf(t))
+				n := int64(len(r))
+				t.alloc(elem, n)
+				arr := newArray(elem, n)
+				for i, c := range r {
+					arr.Elem(t, int64(i)).(IntValue).Set(t, int64(c))
+				}
+				return Slice{arr, n, n}
+			}
+		default:
+			return bad()
+		}
+	}
+
+	// Otherwise, v must have a type with the same underlying type
+	// as t, in which case its value is used unchanged.
+	if expr.eval == nil {
+		if !t.compat(v.t, true) {
+			return bad()
+		}
+		expr.eval = v.eval
+	}
 	return expr
 }
 
+// truncInt truncates x to an integer of the given size, where 0 is
+// the size of int.
+func truncInt(bits uint, x int64) int64 {
+	switch bits {
+	case 8:
+		return int64(int8(x))
+	case 16:
+		return int64(int16(x))
+	case 32:
+		return int64(int32(x))
+	case 0:
+		return int64(int(x))
+	}
+	return x
+}
+
+// truncUint truncates x to an unsigned integer of the given size,
+// where 0 is the size of uint, or uintptr if ptr is true.
+func truncUint(bits uint, ptr bool, x uint64) uint64 {
+	switch bits {
+	case 8:
+		return uint64(uint8(x))
+	case 16:
+		return uint64(uint16(x))
+	case 32:
+		return uint64(uint32(x))
+	case 0:
+		if ptr {
+			return uint64(uintptr(x))
+		}
+		return uint64(uint(x))
+	}
+	return x
+}
+
+// truncFloat rounds x to a floating-point number of the given size.
+func truncFloat(bits uint, x float64) float64 {
+	if bits == 32 {
+		return float64(float32(x))
+	}
+	return x
+}
+
+// intToRune returns the rune with code point x, or the Unicode
+// replacement character if x is not a valid code point.
+func intToRune(x int64) rune {
+	if x < 0 || x > utf8.MaxRune {
+		return utf8.RuneError
+	}
+	return rune(x)
+}
+
 func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *expr {
 	checkCount := func(min, max int) bool {
 		if len(as) < min {
@@ -1682,7 +2394,13 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 			src := srcs[i]
 			srct := src.t.lit()
 
-			if srct.isIdeal() {
+			if _, ok := elmty.lit().(*InterfaceType); ok {
+				src = src.convertForInterface(elmty)
+				if src == nil {
+					return nil
+				}
+				srct = src.t.lit()
+			} else if srct.isIdeal() {
 				src = src.convertTo(elmty)
 				if src == nil {
 					a.diag("cannot convert argument %d (type %s) to type %s in 'append'",
@@ -1703,20 +2421,21 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 		}
 		expr := a.newExpr(NewSliceType(elmty), "function call")
 		inval := as[0].derefArray().asSlice()
+		valfs := make([]func(*Thread) Value, len(srcs))
+		for i, src := range srcs {
+			valfs[i] = src.asValue()
+		}
 		expr.eval = func(t *Thread) Slice {
-			src_sz := inval(t).Len
-			sz := src_sz + int64(len(as[1:]))
-			dstt := NewArrayType(sz, elmty)
-			base := dstt.Zero().(*arrayV)
-			if src_sz > 0 {
-				base.Sub(0, src_sz).Assign(t, inval(t).Base)
+			dst := inval(t)
+			vals := make([]Value, len(valfs))
+			for i, f := range valfs {
+				vals[i] = f(t)
 			}
-			for i := range as[1:] {
-				src := srcs[i]
-				var val Value = src.asValue()(t)
-				base.Elem(t, int64(i)+src_sz).Assign(t, val)
+			res := growSlice(t, dst, int64(len(vals)), elmty)
+			for i, val := range vals {
+				res.Base.Elem(t, dst.Len+int64(i)).Assign(t, val)
 			}
-			return Slice{base, sz, sz}
+			return res
 		}
 		return expr
 
@@ -1737,7 +2456,15 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 			vf := arg.asSlice()
 			expr.eval = func(t *Thread) int64 { return vf(t).Cap }
 
-		//case *ChanType:
+		case *ChanType:
+			vf := arg.asChan()
+			expr.eval = func(t *Thread) int64 {
+				c := vf(t)
+				if c == nil {
+					return 0
+				}
+				return c.Cap(t)
+			}
 
 		default:
 			a.diag("illegal argument type for cap function\n\t%v", arg.t)
@@ -1810,7 +2537,15 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 				return m.Len(t)
 			}
 
-		//case *ChanType:
+		case *ChanType:
+			vf := arg.asChan()
+			expr.eval = func(t *Thread) int64 {
+				c := vf(t)
+				if c == nil {
+					return 0
+				}
+				return c.Len(t)
+			}
 
 		default:
 			a.diag("illegal argument type for len function\n\t%v", arg.t)
@@ -1859,13 +2594,13 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 				// XXX(Spec) What if len or cap is
 				// negative?  The runtime panics.
 				if l < 0 {
-					t.Abort(NegativeLengthError{l})
+					t.Panic(NegativeLengthError{l})
 				}
 				c := l
 				if capf != nil {
 					c = capf(t)
 					if c < 0 {
-						t.Abort(NegativeCapacityError{c})
+						t.Panic(NegativeCapacityError{c})
 					}
 					// XXX(Spec) What happens if
 					// len > cap?  The runtime
@@ -1874,11 +2609,8 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 						c = l
 					}
 				}
-				base := arrayV(make([]Value, c))
-				for i := int64(0); i < c; i++ {
-					base[i] = et.Zero()
-				}
-				return Slice{&base, l, c}
+				t.alloc(et, c)
+				return Slice{newArray(et, c), l, c}
 			}
 			return expr
 
@@ -1890,25 +2622,75 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 			if !checkCount(1, 2) {
 				return nil
 			}
+			mt := t
 			expr := a.newExpr(t, "function call")
 			expr.eval = func(t *Thread) Map {
 				if lenf == nil {
 					return make(evalMap)
 				}
 				l := lenf(t)
+				t.alloc(mt.Key, l)
+				t.alloc(mt.Elem, l)
 				return make(evalMap, l)
 			}
 			return expr
 
-		//case *ChanType:
+		case *ChanType:
+			// A new channel is made using the built-in
+			// function make, which takes the channel type
+			// and an optional buffer size as arguments.
+			if !checkCount(1, 2) {
+				return nil
+			}
+			et := t.Elem
+			expr := a.newExpr(t, "function call")
+			expr.eval = func(t *Thread) Chan {
+				if lenf == nil {
+					return newEvalChan(et, 0)
+				}
+				l := lenf(t)
+				if l < 0 {
+					t.Panic(NegativeCapacityError{l})
+				}
+				t.alloc(et, l)
+				return newEvalChan(et, l)
+			}
+			return expr
 
 		default:
 			a.diag("illegal argument type for make function\n\t%v", as[0].valType)
 			return nil
 		}
 
-	case closeType, closedType:
-		a.diag("built-in function %s not implemented", ft.builtin)
+	case closeType:
+		if !checkCount(1, 1) {
+			return nil
+		}
+		ct, ok := as[0].t.lit().(*ChanType)
+		if !ok {
+			a.diag("illegal argument type for close function\n\t%v", as[0].t)
+			return nil
+		}
+		if ct.Dir == ast.RECV {
+			a.diag("cannot close receive-only channel %v", as[0].t)
+			return nil
+		}
+		vf := as[0].asChan()
+		expr := a.newExpr(EmptyType, "function call")
+		expr.exec = func(t *Thread) {
+			c := vf(t)
+			if c == nil {
+				panic("close of nil channel")
+			}
+			c.Close(t)
+		}
+		return expr
+
+	case closedType:
+		// closed is out of scope: its result is stale as soon as
+		// another goroutine touches the channel, which is why Go
+		// dropped it for the comma-ok receive.
+		a.diag("built-in function %s is not supported; use v, ok := <-ch", ft.builtin)
 		return nil
 
 	case newType:
@@ -1918,10 +2700,42 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 
 		t := as[0].valType
 		expr := a.newExpr(NewPtrType(t), "new")
-		expr.eval = func(*Thread) Value { return t.Zero() }
+		expr.eval = func(th *Thread) Value {
+			th.alloc(t, 1)
+			return t.Zero()
+		}
 		return expr
 
-	case panicType, printType, printlnType:
+	case panicType:
+		if !checkCount(1, 1) {
+			return nil
+		}
+
+		arg := as[0]
+		if arg.t == NilType {
+			arg = arg.convertNil(EmptyInterfaceType)
+		} else {
+			arg = arg.convertToInterface(EmptyInterfaceType)
+		}
+		if arg == nil {
+			return nil
+		}
+		vf := arg.asIface()
+		expr := a.newExpr(EmptyType, "panic")
+		expr.exec = func(t *Thread) { panic(&PanicError{vf(t)}) }
+		return expr
+
+	case recoverType:
+		if !checkCount(0, 0) {
+			return nil
+		}
+
+		expr := a.newExpr(EmptyInterfaceType, "recover")
+		expr.eval = func(t *Thread) Interface { return t.recover() }
+		expr.exec = func(t *Thread) { t.recover() }
+		return expr
+
+	case printType, printlnType:
 		evals := make([]func(*Thread) interface{}, len(as))
 		for i, x := range as {
 			evals[i] = x.asInterface()
@@ -1950,6 +2764,12 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 					print(v1)
 				case stringer:
 					print(v1.String())
+				case Interface:
+					if v1.Value == nil {
+						print("<nil>")
+					} else {
+						print(v1.Value.String())
+					}
 				default:
 					print("???")
 				}
@@ -1960,12 +2780,6 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 		}
 		expr := a.newExpr(EmptyType, "print")
 		expr.exec = printer
-		if ft == panicType {
-			expr.exec = func(t *Thread) {
-				printer(t)
-				t.Abort(errors.New("panic"))
-			}
-		}
 		return expr
 	}
 
@@ -1973,6 +2787,75 @@ func (a *exprInfo) compileBuiltinCallExpr(b *block, ft *FuncType, as []*expr) *e
 	panic("unreachable")
 }
 
+func (a *exprInfo) compileTypeAssertExpr(v *expr, at Type) *expr {
+	it, ok := v.t.lit().(*InterfaceType)
+	if !ok {
+		a.diag("invalid type assertion: %v is not an interface", v.t)
+		return nil
+	}
+	_, toIface := at.lit().(*InterfaceType)
+	if !toIface {
+		if m, ok := it.implementedBy(at); !ok {
+			a.diag("impossible type assertion: %v does not implement %v (missing %s method)", at, v.t, m.Name)
+			return nil
+		}
+	}
+
+	vf := v.asIface()
+	vt := v.t
+	assert := func(t *Thread) (Interface, error) {
+		i := vf(t)
+		if i.Type == nil {
+			return i, TypeAssertionError{vt, nil, at, ""}
+		}
+		if toIface {
+			if m, ok := at.lit().(*InterfaceType).implementedBy(i.Type); !ok {
+				return i, TypeAssertionError{vt, i.Type, at, m.Name}
+			}
+		} else if !at.compat(i.Type, false) {
+			return i, TypeAssertionError{vt, i.Type, at, ""}
+		}
+		return i, nil
+	}
+
+	expr := a.newExpr(at, "type assertion")
+	if toIface {
+		expr.eval = func(t *Thread) Interface {
+			i, err := assert(t)
+			if err != nil {
+				t.Panic(err)
+			}
+			return i
+		}
+		expr.evalCommaOk = func(t *Thread) (Value, bool) {
+			i, err := assert(t)
+			if err != nil {
+				return at.Zero(), false
+			}
+			return &interfaceV{i}, true
+		}
+	} else {
+		expr.genValue(func(t *Thread) Value {
+			i, err := assert(t)
+			if err != nil {
+				t.Panic(err)
+			}
+			return i.Value
+		})
+		// The dynamic value of an interface is not
+		// addressable.
+		expr.evalAddr = nil
+		expr.evalCommaOk = func(t *Thread) (Value, bool) {
+			i, err := assert(t)
+			if err != nil {
+				return at.Zero(), false
+			}
+			return i.Value, true
+		}
+	}
+	return expr
+}
+
 func (a *exprInfo) compileStarExpr(v *expr) *expr {
 	switch vt := v.t.lit().(type) {
 	case *PtrType:
@@ -1981,7 +2864,7 @@ func (a *exprInfo) compileStarExpr(v *expr) *expr {
 		expr.genValue(func(t *Thread) Value {
 			v := vf(t)
 			if v == nil {
-				t.Abort(NilPointerError{})
+				t.Panic(NilPointerError{})
 			}
 			return v
 		})
@@ -1992,8 +2875,6 @@ func (a *exprInfo) compileStarExpr(v *expr) *expr {
 	return nil
 }
 
-var unaryOpDescs = make(map[token.Token]string)
-
 func (a *exprInfo) compileUnaryExpr(op token.Token, v *expr) *expr {
 	// Type check
 	var t Type
@@ -2036,17 +2917,23 @@ func (a *exprInfo) compileUnaryExpr(op token.Token, v *expr) *expr {
 		t = NewPtrType(v.t)
 
 	case token.ARROW:
-		log.Panicf("Unary op %v not implemented", op)
+		ct, ok := v.t.lit().(*ChanType)
+		if !ok {
+			a.diag("cannot receive from non-channel %v", v.t)
+			return nil
+		}
+		if ct.Dir == ast.SEND {
+			a.diag("cannot receive from send-only channel %v", v.t)
+			return nil
+		}
+		t = ct.Elem
 
 	default:
-		log.Panicf("unknown unary operator %v", op)
+		a.diag("unary operator %v not implemented", op)
+		return nil
 	}
 
-	desc, ok := unaryOpDescs[op]
-	if !ok {
-		desc = "unary " + op.String() + " expression"
-		unaryOpDescs[op] = desc
-	}
+	desc := "unary " + op.String() + " expression"
 
 	// Compile
 	expr := a.newExpr(t, desc)
@@ -2069,6 +2956,25 @@ func (a *exprInfo) compileUnaryExpr(op token.Token, v *expr) *expr {
 		vf := v.evalAddr
 		expr.eval = func(t *Thread) Value { return vf(t) }
 
+	case token.ARROW:
+		vf := v.asChan()
+		recv := func(t *Thread) (Value, bool) {
+			c := vf(t)
+			if c == nil {
+				// Receiving from a nil channel blocks
+				// forever.
+				t.block()
+			}
+			return c.Recv(t)
+		}
+		expr.genValue(func(t *Thread) Value {
+			v, _ := recv(t)
+			return v
+		})
+		expr.evalAddr = nil
+		expr.exec = func(t *Thread) { recv(t) }
+		expr.evalCommaOk = recv
+
 	default:
 		log.Panicf("Compilation of unary op %v not implemented", op)
 	}
@@ -2076,8 +2982,6 @@ func (a *exprInfo) compileUnaryExpr(op token.Token, v *expr) *expr {
 	return expr
 }
 
-var binOpDescs = make(map[token.Token]string)
-
 func (a *exprInfo) compileBinaryExpr(op token.Token, l, r *expr) *expr {
 	// Save the original types of l.t and r.t for error messages.
 	origlt := l.t
@@ -2292,6 +3196,40 @@ func (a *exprInfo) compileBinaryExpr(op token.Token, l, r *expr) *expr {
 
 		// TODO(austin) Deal with remaining special cases
 
+		// nil may be compared with any pointer, function,
+		// slice, map, or interface value.
+		if l.t == NilType && r.t != NilType {
+			l = l.convertNil(r.t)
+		} else if r.t == NilType && l.t != NilType {
+			r = r.convertNil(l.t)
+		} else if l.t == NilType {
+			a.diagOpTypes(op, origlt, origrt)
+			return nil
+		}
+		if l == nil || r == nil {
+			return nil
+		}
+
+		// A value may be compared with an interface value if
+		// its type implements the interface.
+		implements := func(it, t Type) bool {
+			lit, ok := it.lit().(*InterfaceType)
+			if ok {
+				_, ok = lit.implementedBy(t)
+			}
+			return ok
+		}
+		if !compat() {
+			if implements(l.t, r.t) {
+				r = r.convertToInterface(l.t)
+			} else if implements(r.t, l.t) {
+				l = l.convertToInterface(r.t)
+			}
+		}
+		if l == nil || r == nil {
+			return nil
+		}
+
 		if !compat() {
 			a.diagOpTypes(op, origlt, origrt)
 			return nil
@@ -2302,17 +3240,19 @@ func (a *exprInfo) compileBinaryExpr(op token.Token, l, r *expr) *expr {
 			a.diagOpTypes(op, origlt, origrt)
 			return nil
 		}
+		// Slices may only be compared to nil.
+		if _, ok := l.t.lit().(*SliceType); ok && origlt != NilType && origrt != NilType {
+			a.diagOpTypes(op, origlt, origrt)
+			return nil
+		}
 		t = BoolType
 
 	default:
-		log.Panicf("unknown binary operator %v", op)
+		a.diag("binary operator %v not implemented", op)
+		return nil
 	}
 
-	desc, ok := binOpDescs[op]
-	if !ok {
-		desc = op.String() + " expression"
-		binOpDescs[op] = desc
-	}
+	desc := op.String() + " expression"
 
 	// Check for ideal divide by zero
 	switch op {
@@ -2416,7 +3356,15 @@ func (a *exprInfo) compileBinaryExpr(op token.Token, l, r *expr) *expr {
 func (a *exprInfo) compilePackageImport(name string, pkg *PkgIdent, constant, callCtx bool) *expr {
 	fields := make([]packageField, 0)
 	values := make([]Value, 0)
-	for k, v := range pkg.scope.defs {
+	// Visit the definitions in a fixed order, so that the fields
+	// line up with those of an earlier import of the package.
+	names := make([]string, 0, len(pkg.scope.defs))
+	for k := range pkg.scope.defs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := pkg.scope.defs[k]
 		// filter out non-exported definitions
 		if !ast.IsExported(k) {
 			continue
@@ -2439,11 +3387,14 @@ func (a *exprInfo) compilePackageImport(name string, pkg *PkgIdent, constant, ca
 		default:
 			log.Panicf("unhandled Def type (%T)", vv)
 		}
-		field := packageField{k, fty}
+		field := packageField{k, fty, nil}
+		if fty.isIdeal() {
+			field.Const = fva
+		}
 		fields = append(fields, field)
 		values = append(values, fva)
 	}
-	pkgty := newPackageType(name, fields)
+	pkgty := newPackageType(pkg.scope, fields)
 	pkg_expr := a.newExpr(pkgty, "package")
 	idents := []*expr{}
 	for _, f := range pkgty.Elems {
@@ -51,6 +51,12 @@ func (a *expr) asSlice() func(*Thread) Slice {
 func (a *expr) asMap() func(*Thread) Map {
 	return a.eval.(func(*Thread) Map)
 }
+func (a *expr) asIface() func(*Thread) Interface {
+	return a.eval.(func(*Thread) Interface)
+}
+func (a *expr) asChan() func(*Thread) Chan {
+	return a.eval.(func(*Thread) Chan)
+}
 func (a *expr) asMulti() func(*Thread) []Value {
 	return a.eval.(func(*Thread) []Value)
 }
@@ -83,6 +89,10 @@ func (a *expr) asInterface() func(*Thread) interface{} {
 		return func(t *Thread) interface{} { return sf(t) }
 	case func(t *Thread) Map:
 		return func(t *Thread) interface{} { return sf(t) }
+	case func(t *Thread) Interface:
+		return func(t *Thread) interface{} { return sf(t) }
+	case func(t *Thread) Chan:
+		return func(t *Thread) interface{} { return sf(t) }
 	default:
 		log.Panicf("unexpected expression node type %T at %v", a.eval, a.pos)
 	}
@@ -123,6 +133,10 @@ func (a *expr) genConstant(v Value) {
 		a.eval = func(t *Thread) Slice { return v.(SliceValue).Get(t) }
 	case *MapType:
 		a.eval = func(t *Thread) Map { return v.(MapValue).Get(t) }
+	case *InterfaceType:
+		a.eval = func(t *Thread) Interface { return v.(InterfaceValue).Get(t) }
+	case *nilType:
+		a.eval = func(t *Thread) Value { return nil }
 	default:
 		log.Panicf("unexpected constant type %v at %v", a.t, a.pos)
 	}
@@ -153,6 +167,10 @@ func (a *expr) genIdentOp(level, index int) {
 		a.eval = func(t *Thread) Slice { return t.f.Get(level, index).(SliceValue).Get(t) }
 	case *MapType:
 		a.eval = func(t *Thread) Map { return t.f.Get(level, index).(MapValue).Get(t) }
+	case *InterfaceType:
+		a.eval = func(t *Thread) Interface { return t.f.Get(level, index).(InterfaceValue).Get(t) }
+	case *ChanType:
+		a.eval = func(t *Thread) Chan { return t.f.Get(level, index).(ChanValue).Get(t) }
 	default:
 		log.Panicf("unexpected identifier type %v at %v", a.t, a.pos)
 	}
@@ -183,6 +201,10 @@ func (a *expr) genFuncCall(call func(t *Thread) []Value) {
 		a.eval = func(t *Thread) Slice { return call(t)[0].(SliceValue).Get(t) }
 	case *MapType:
 		a.eval = func(t *Thread) Map { return call(t)[0].(MapValue).Get(t) }
+	case *InterfaceType:
+		a.eval = func(t *Thread) Interface { return call(t)[0].(InterfaceValue).Get(t) }
+	case *ChanType:
+		a.eval = func(t *Thread) Chan { return call(t)[0].(ChanValue).Get(t) }
 	case *MultiType:
 		a.eval = func(t *Thread) []Value { return call(t) }
 	default:
@@ -215,6 +237,10 @@ func (a *expr) genValue(vf func(*Thread) Value) {
 		a.eval = func(t *Thread) Slice { return vf(t).(SliceValue).Get(t) }
 	case *MapType:
 		a.eval = func(t *Thread) Map { return vf(t).(MapValue).Get(t) }
+	case *InterfaceType:
+		a.eval = func(t *Thread) Interface { return vf(t).(InterfaceValue).Get(t) }
+	case *ChanType:
+		a.eval = func(t *Thread) Chan { return vf(t).(ChanValue).Get(t) }
 	default:
 		log.Panicf("unexpected result type %v at %v", a.t, a.pos)
 	}
@@ -229,15 +255,13 @@ func (a *expr) genUnaryOpNeg(v *expr) {
 		vf := v.asInt()
 		a.eval = func(t *Thread) int64 { v := vf(t); return -v }
 	case *idealIntType:
-		val := v.asIdealInt()()
-		val.Neg(val)
+		val := new(big.Int).Neg(v.asIdealInt()())
 		a.eval = func() *big.Int { return val }
 	case *floatType:
 		vf := v.asFloat()
 		a.eval = func(t *Thread) float64 { v := vf(t); return -v }
 	case *idealFloatType:
-		val := v.asIdealFloat()()
-		val.Neg(val)
+		val := new(big.Rat).Neg(v.asIdealFloat()())
 		a.eval = func() *big.Rat { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", a.t, a.pos)
@@ -263,8 +287,7 @@ func (a *expr) genUnaryOpXor(v *expr) {
 		vf := v.asInt()
 		a.eval = func(t *Thread) int64 { v := vf(t); return ^v }
 	case *idealIntType:
-		val := v.asIdealInt()()
-		val.Not(val)
+		val := new(big.Int).Not(v.asIdealInt()())
 		a.eval = func() *big.Int { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", a.t, a.pos)
@@ -372,7 +395,7 @@ func (a *expr) genBinOpAdd(l, r *expr) {
 	case *idealIntType:
 		l := l.asIdealInt()()
 		r := r.asIdealInt()()
-		val := l.Add(l, r)
+		val := new(big.Int).Add(l, r)
 		a.eval = func() *big.Int { return val }
 	case *floatType:
 		lf := l.asFloat()
@@ -398,13 +421,14 @@ func (a *expr) genBinOpAdd(l, r *expr) {
 	case *idealFloatType:
 		l := l.asIdealFloat()()
 		r := r.asIdealFloat()()
-		val := l.Add(l, r)
+		val := new(big.Rat).Add(l, r)
 		a.eval = func() *big.Rat { return val }
 	case *stringType:
 		lf := l.asString()
 		rf := r.asString()
 		a.eval = func(t *Thread) string {
 			l, r := lf(t), rf(t)
+			t.alloc(Uint8Type, int64(len(l))+int64(len(r)))
 			return l + r
 		}
 	default:
@@ -501,7 +525,7 @@ func (a *expr) genBinOpSub(l, r *expr) {
 	case *idealIntType:
 		l := l.asIdealInt()()
 		r := r.asIdealInt()()
-		val := l.Sub(l, r)
+		val := new(big.Int).Sub(l, r)
 		a.eval = func() *big.Int { return val }
 	case *floatType:
 		lf := l.asFloat()
@@ -527,7 +551,7 @@ func (a *expr) genBinOpSub(l, r *expr) {
 	case *idealFloatType:
 		l := l.asIdealFloat()()
 		r := r.asIdealFloat()()
-		val := l.Sub(l, r)
+		val := new(big.Rat).Sub(l, r)
 		a.eval = func() *big.Rat { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
@@ -623,7 +647,7 @@ func (a *expr) genBinOpMul(l, r *expr) {
 	case *idealIntType:
 		l := l.asIdealInt()()
 		r := r.asIdealInt()()
-		val := l.Mul(l, r)
+		val := new(big.Int).Mul(l, r)
 		a.eval = func() *big.Int { return val }
 	case *floatType:
 		lf := l.asFloat()
@@ -649,7 +673,7 @@ func (a *expr) genBinOpMul(l, r *expr) {
 	case *idealFloatType:
 		l := l.asIdealFloat()()
 		r := r.asIdealFloat()()
-		val := l.Mul(l, r)
+		val := new(big.Rat).Mul(l, r)
 		a.eval = func() *big.Rat { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
@@ -667,7 +691,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return uint64(uint8(ret))
@@ -677,7 +701,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return uint64(uint16(ret))
@@ -687,7 +711,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return uint64(uint32(ret))
@@ -697,7 +721,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return uint64(uint64(ret))
@@ -707,7 +731,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return uint64(uint(ret))
@@ -724,7 +748,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return int64(int8(ret))
@@ -734,7 +758,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return int64(int16(ret))
@@ -744,7 +768,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return int64(int32(ret))
@@ -754,7 +778,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return int64(int64(ret))
@@ -764,7 +788,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return int64(int(ret))
@@ -775,7 +799,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 	case *idealIntType:
 		l := l.asIdealInt()()
 		r := r.asIdealInt()()
-		val := l.Quo(l, r)
+		val := new(big.Int).Quo(l, r)
 		a.eval = func() *big.Int { return val }
 	case *floatType:
 		lf := l.asFloat()
@@ -786,7 +810,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret float64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return float64(float32(ret))
@@ -796,7 +820,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret float64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l / r
 				return float64(float64(ret))
@@ -807,7 +831,7 @@ func (a *expr) genBinOpQuo(l, r *expr) {
 	case *idealFloatType:
 		l := l.asIdealFloat()()
 		r := r.asIdealFloat()()
-		val := l.Quo(l, r)
+		val := new(big.Rat).Quo(l, r)
 		a.eval = func() *big.Rat { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
@@ -825,7 +849,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return uint64(uint8(ret))
@@ -835,7 +859,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return uint64(uint16(ret))
@@ -845,7 +869,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return uint64(uint32(ret))
@@ -855,7 +879,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return uint64(uint64(ret))
@@ -865,7 +889,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret uint64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return uint64(uint(ret))
@@ -882,7 +906,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return int64(int8(ret))
@@ -892,7 +916,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return int64(int16(ret))
@@ -902,7 +926,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return int64(int32(ret))
@@ -912,7 +936,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return int64(int64(ret))
@@ -922,7 +946,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 				l, r := lf(t), rf(t)
 				var ret int64
 				if r == 0 {
-					t.Abort(DivByZeroError{})
+					t.Panic(DivByZeroError{})
 				}
 				ret = l % r
 				return int64(int(ret))
@@ -933,7 +957,7 @@ func (a *expr) genBinOpRem(l, r *expr) {
 	case *idealIntType:
 		l := l.asIdealInt()()
 		r := r.asIdealInt()()
-		val := l.Rem(l, r)
+		val := new(big.Int).Rem(l, r)
 		a.eval = func() *big.Int { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
@@ -1029,7 +1053,7 @@ func (a *expr) genBinOpAnd(l, r *expr) {
 	case *idealIntType:
 		l := l.asIdealInt()()
 		r := r.asIdealInt()()
-		val := l.And(l, r)
+		val := new(big.Int).And(l, r)
 		a.eval = func() *big.Int { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
@@ -1125,7 +1149,7 @@ func (a *expr) genBinOpOr(l, r *expr) {
 	case *idealIntType:
 		l := l.asIdealInt()()
 		r := r.asIdealInt()()
-		val := l.Or(l, r)
+		val := new(big.Int).Or(l, r)
 		a.eval = func() *big.Int { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
@@ -1221,7 +1245,7 @@ func (a *expr) genBinOpXor(l, r *expr) {
 	case *idealIntType:
 		l := l.asIdealInt()()
 		r := r.asIdealInt()()
-		val := l.Xor(l, r)
+		val := new(big.Int).Xor(l, r)
 		a.eval = func() *big.Int { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
@@ -1317,7 +1341,7 @@ func (a *expr) genBinOpAndNot(l, r *expr) {
 	case *idealIntType:
 		l := l.asIdealInt()()
 		r := r.asIdealInt()()
-		val := l.AndNot(l, r)
+		val := new(big.Int).AndNot(l, r)
 		a.eval = func() *big.Int { return val }
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
@@ -1754,6 +1778,27 @@ func (a *expr) genBinOpEql(l, r *expr) {
 			l, r := lf(t), rf(t)
 			return l == r
 		}
+	case *SliceType:
+		lf := l.asSlice()
+		rf := r.asSlice()
+		a.eval = func(t *Thread) bool {
+			l, r := lf(t), rf(t)
+			return l.Base == r.Base
+		}
+	case *InterfaceType:
+		lf := l.asIface()
+		rf := r.asIface()
+		a.eval = func(t *Thread) bool {
+			l, r := lf(t), rf(t)
+			return interfacesEqual(t, l, r)
+		}
+	case *ChanType:
+		lf := l.asChan()
+		rf := r.asChan()
+		a.eval = func(t *Thread) bool {
+			l, r := lf(t), rf(t)
+			return l == r
+		}
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
 	}
@@ -1827,6 +1872,27 @@ func (a *expr) genBinOpNeq(l, r *expr) {
 			l, r := lf(t), rf(t)
 			return l != r
 		}
+	case *SliceType:
+		lf := l.asSlice()
+		rf := r.asSlice()
+		a.eval = func(t *Thread) bool {
+			l, r := lf(t), rf(t)
+			return l.Base != r.Base
+		}
+	case *InterfaceType:
+		lf := l.asIface()
+		rf := r.asIface()
+		a.eval = func(t *Thread) bool {
+			l, r := lf(t), rf(t)
+			return !interfacesEqual(t, l, r)
+		}
+	case *ChanType:
+		lf := l.asChan()
+		rf := r.asChan()
+		a.eval = func(t *Thread) bool {
+			l, r := lf(t), rf(t)
+			return l != r
+		}
 	default:
 		log.Panicf("unexpected type %v at %v", l.t, a.pos)
 	}
@@ -1867,6 +1933,12 @@ func genAssign(lt Type, r *expr) func(lv Value, t *Thread) {
 	case *MapType:
 		rf := r.asMap()
 		return func(lv Value, t *Thread) { lv.(MapValue).Set(t, rf(t)) }
+	case *InterfaceType:
+		rf := r.asIface()
+		return func(lv Value, t *Thread) { lv.(InterfaceValue).Set(t, rf(t)) }
+	case *ChanType:
+		rf := r.asChan()
+		return func(lv Value, t *Thread) { lv.(ChanValue).Set(t, rf(t)) }
 	default:
 		log.Panicf("unexpected left operand type %v at %v", lt, r.pos)
 	}
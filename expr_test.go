@@ -122,7 +122,7 @@ var exprTests = []test{
 	Val("append(sli, 3)", vslice{varray{1, 2, 3}, 3, 3}),
 	Val("append(sli, 3, 4)", vslice{varray{1, 2, 3, 4}, 4, 4}),
 	//FIXME: implement ellipsis unpacking
-	//Val("append(sli, []int{3,4}...)", vslice{varray{1, 2, 3, 4}, 4, 4}),
+	Val("append(sli, []int{3,4}...)", vslice{varray{1, 2, 3, 4}, 4, 4}),
 	Val("append(sli, 3.0)", vslice{varray{1, 2, 3}, 3, 3}),
 	CErr("append(sli, 3.1)", "cannot convert argument 1 [(]type ideal float[)] to type int in 'append'"),
 	CErr("append(sli, \"2\")", "cannot use string literal [(]type <string>[)] as type int in 'append'"),
@@ -403,6 +403,33 @@ var exprTests = []test{
 	Val1("type S struct {i int; j float64; k float64}; ss := S{j:2.1,i:1,k:3.1}", "ss", vstruct{[]interface{}{1, 2.1, 3.1}, strt}),
 	Val1("type S struct {i int; j float64; k float64}; ss := S{j:2.1,k:3.1,i:1}", "ss", vstruct{[]interface{}{1, 2.1, 3.1}, strt}),
 	CErr(`type S struct {i int; j float64; k float64}; ss := S{i:1.1,j:2.1,k:3.1}`, "cannot convert literal #1 [(]type ideal float[)] to type int"),
+	// Conversions
+	Val("int(f)", 1),
+	Val("float64(i) / 2", 0.5),
+	Val("uint(i)", uint(1)),
+	Val("int(int8(i + 127))", -128),
+	Val("int(uint8(-i))", 255),
+	Val("int(int64(i2) * 3)", 6),
+	Val("int(2.0)", 2),
+	Val("string(65)", "A"),
+	Val("string(i + 64)", "A"),
+	Val("string(-1)", "\uFFFD"),
+	Val("string([]byte(s)[1:])", "bc"),
+	Val("len([]rune(\"h\u00e9\"))", 2),
+	Val("string([]rune{104, 233})", "h\u00e9"),
+	Val("uint8([]byte(s)[0])", uint8('a')),
+	Val1("type T int; var x T = 3", "int(x) + i", 4),
+	Val1("type T int; x := T(i) + 2", "int(x)", 3),
+	Val1("type S struct { a int }; type R struct { a int }; x := R(S{5})", "x.a", 5),
+	Val1("type P *int; x := 1; p := P(&x)", "*(*int)(p)", 1),
+	Val1("type N string; x := N(s)", "string(x) + \"!\"", "abc!"),
+	CErr("int(2.5)", constantTruncated),
+	CErr("uint8(256)", constantOverflows),
+	CErr("string(1.5)", "cannot convert"),
+	CErr("int(s)", "cannot convert"),
+	CErr("[]int(s)", "cannot convert"),
+	CErr("int()", "missing argument"),
+	CErr("int(1, 2)", "too many arguments"),
 }
 
 func TestExpr(t *testing.T) { runTests(t, "exprTests", exprTests) }
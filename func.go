@@ -5,7 +5,9 @@
 package chicklet
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 )
 
 /*
@@ -18,6 +20,137 @@ type Thread struct {
 	// The execution frame of this function.  This remains the
 	// same throughout a function invocation.
 	f *Frame
+	// The calls deferred by the current function invocation, in
+	// the order they were deferred.
+	defers []func(*Thread)
+	// The panic that the deferred call currently running may
+	// recover, or nil.
+	panicking *PanicError
+	// The frame of the deferred call currently running.  Only
+	// code running directly in this frame may recover.
+	deferFrame *Frame
+	// The limits of the run this thread belongs to, or nil.  They
+	// are shared with the goroutines and callbacks it starts.
+	limits *limits
+	// The number of interpreted calls in progress on this thread,
+	// and how many there may be, or 0 for DefaultMaxCallDepth.
+	depth, maxDepth int
+	// The call depth of the innermost native call in progress,
+	// which callbacks from native code continue at.  The threads
+	// of a World share it, since a callback may be called on
+	// behalf of any of them.
+	nativeDepth *int64
+	// The copies of script memory passed to the native call in
+	// progress, written back when it returns.  nil outside of
+	// native calls.
+	copies []nativeCopy
+}
+
+// DefaultMaxCallDepth is the maximum depth of interpreted calls on a
+// thread whose Spec does not set one.  It keeps the native stack well
+// within what Go allows.
+const DefaultMaxCallDepth = 10000
+
+// spawn returns a new thread running in frame f under the same limits
+// as t.
+func (t *Thread) spawn(f *Frame) *Thread {
+	nt := &Thread{f: f}
+	if t != nil {
+		nt.limits, nt.maxDepth, nt.nativeDepth = t.limits, t.maxDepth, t.nativeDepth
+	}
+	return nt
+}
+
+// callback returns a new thread for a call from native code back into
+// the interpreter, under the same limits as t.  It continues at the
+// depth of the native call in progress.
+func (t *Thread) callback() *Thread {
+	nt := t.spawn(nil)
+	if nt.nativeDepth != nil {
+		nt.depth = int(atomic.LoadInt64(nt.nativeDepth))
+	}
+	return nt
+}
+
+// limits holds the resources a run may use, and what it has used so
+// far.  The counters are updated atomically, since the goroutines of
+// a run share them.
+type limits struct {
+	// The context the run is cancelled by, and its Done channel.
+	ctx  context.Context
+	done <-chan struct{}
+	maxSteps int64
+	steps    int64
+	// The bytes the run may allocate, and the bytes allocated so
+	// far, which the runs in a World share.
+	maxMemory int64
+	memory    *int64
+}
+
+// newLimits returns the limits of a run under spec, cancelled by ctx,
+// or nil if it has none.  memory counts the bytes allocated by the
+// World the run belongs to, if any.
+func newLimits(ctx context.Context, spec *Spec, memory *int64) *limits {
+	if ctx.Done() == nil && spec.MaxSteps <= 0 && spec.MaxMemory <= 0 {
+		return nil
+	}
+	if memory == nil {
+		memory = new(int64)
+	}
+	return &limits{ctx: ctx, done: ctx.Done(), maxSteps: spec.MaxSteps, maxMemory: spec.MaxMemory, memory: memory}
+}
+
+// alloc accounts for the allocation of n values of type typ by t,
+// aborting t if the run would exceed its memory limit.  It must be
+// called before allocating, so that oversized allocations never
+// happen.
+func (t *Thread) alloc(typ Type, n int64) {
+	l := t.limits
+	if l == nil || l.maxMemory <= 0 || n <= 0 {
+		return
+	}
+	size := sizeOf(typ)
+	if size > 0 && n > l.maxMemory/size {
+		t.Abort(&MemoryError{l.maxMemory})
+	}
+	// Allocations that fail are not accounted.
+	if atomic.AddInt64(l.memory, n*size) > l.maxMemory {
+		atomic.AddInt64(l.memory, -n*size)
+		t.Abort(&MemoryError{l.maxMemory})
+	}
+}
+
+// step counts an instruction executed by t, aborting t if the run has
+// exhausted its budget.
+func (l *limits) step(t *Thread) {
+	if l.maxSteps > 0 && atomic.AddInt64(&l.steps, 1) > l.maxSteps {
+		t.Abort(&BudgetError{l.maxSteps})
+	}
+}
+
+// poll aborts t if the run has been cancelled.
+func (l *limits) poll(t *Thread) {
+	select {
+	case <-l.done:
+		t.Abort(l.ctx.Err())
+	default:
+	}
+}
+
+// done returns a channel that is closed when t's run is cancelled, or
+// nil if it cannot be.
+func (t *Thread) done() <-chan struct{} {
+	if t.limits == nil {
+		return nil
+	}
+	return t.limits.done
+}
+
+// block blocks t forever, as operations on nil channels do, unless its
+// run is cancelled.
+func (t *Thread) block() {
+	<-t.done()
+	t.Abort(t.limits.ctx.Err())
 }
 
 type code []func(*Thread)
@@ -27,9 +160,17 @@ func (i code) exec(t *Thread) {
 	t.pc = 0
 	l := uint(len(i))
 	for t.pc < l {
+		if t.limits != nil {
+			t.limits.step(t)
+		}
 		pc := t.pc
 		t.pc++
 		i[pc](t)
+		if t.pc <= pc && t.limits != nil {
+			// Every loop jumps backwards, so this is
+			// often enough to notice cancellation.
+			t.limits.poll(t)
+		}
 	}
 	t.pc = opc
 }
@@ -61,6 +202,26 @@ func (b *codeBuf) get() code {
  * User-defined functions
  */
 
+// An executor is a function that can be executed on a given thread.
+type executor interface {
+	execute(thread *Thread, things []Thing) ([]Thing, error)
+}
+
+// A worldFunc is a function returned by World.Eval.  It runs under
+// the limits of the World's Spec.
+type worldFunc struct {
+	w *World
+	f executor
+}
+
+func (f *worldFunc) Execute(things... Thing) ([]Thing, error) {
+	return f.ExecuteContext(context.Background(), things...)
+}
+
+func (f *worldFunc) ExecuteContext(ctx context.Context, things... Thing) ([]Thing, error) {
+	return f.f.execute(f.w.newThread(ctx), things)
+}
+
 type evalFunc struct {
 	outer     *Frame
 	frameSize int
@@ -70,26 +231,179 @@ type evalFunc struct {
 }
 
 func (f *evalFunc) Execute(things... Thing) ([]Thing, error) {
+	return f.execute(&Thread{}, things)
+}
+
+func (f *evalFunc) ExecuteContext(ctx context.Context, things... Thing) ([]Thing, error) {
+	return f.execute(&Thread{limits: newLimits(ctx, &Spec{}, nil)}, things)
+}
+
+func (f *evalFunc) execute(thread *Thread, things []Thing) ([]Thing, error) {
 	if len(things) != len(f.inTypes) {
 		return nil, &CallError{fmt.Sprint("Wrong number of arguments. Wanted ", len(f.inTypes), " but got ", len(things))}
 	}
 	frame := f.NewFrame()
-	thread := &Thread{}
 	for index, thing := range things {
-		frame.Vars[index] = ValueFromNative(thing, thread)
+		frame.Vars[index] = valueOfType(thread, thing, f.inTypes[index])
 	}
 	for index, t := range f.outTypes {
 		frame.Vars[len(f.inTypes) + index] = t.(Type).Zero()
 	}
 	thread.f = frame
-	f.Call(thread)
+	if err := thread.Try(f.Call); err != nil {
+		return nil, err
+	}
 	var rval []Thing
-	for index, _ := range f.outTypes {
-		rval = append(rval, frame.Vars[len(f.inTypes) + index].GetNative(thread))
+	for index, t := range f.outTypes {
+		rval = append(rval, nativeThing(thread, frame.Vars[len(f.inTypes) + index], t))
 	}
 	return rval, nil
 }
 
 func (f *evalFunc) NewFrame() *Frame { return f.outer.child(f.frameSize) }
 
-func (f *evalFunc) Call(t *Thread) { f.code.exec(t) }
+func (f *evalFunc) Call(t *Thread) {
+	if t.limits != nil {
+		t.limits.poll(t)
+	}
+	// Each interpreted call grows the native stack, so deep
+	// recursion must stop before Go's stack limit is reached.
+	max := t.maxDepth
+	if max <= 0 {
+		max = DefaultMaxCallDepth
+	}
+	if t.depth >= max {
+		t.Abort(&StackOverflowError{max})
+	}
+	t.depth++
+	fr, pc := t.f, t.pc
+	od := t.defers
+	t.defers = nil
+	returned := false
+	defer func() {
+		t.depth--
+		var p *PanicError
+		if !returned {
+			r := recover()
+			if r == nil {
+				// The thread was aborted; don't run
+				// anything else on it.
+				return
+			}
+			if err := t.limitError(r); err != nil {
+				// A callback from native code ran out
+				// of limits, so this thread has too.
+				t.Abort(err)
+			}
+			t.f, t.pc = fr, pc
+			p = toPanicError(r)
+		}
+		p = t.runDefers(p)
+		t.defers = od
+		if p != nil {
+			panic(p)
+		}
+	}()
+	f.code.exec(t)
+	returned = true
+}
+
+// runDefers runs the calls deferred by the current function
+// invocation in reverse order while p is propagating.  It returns the
+// panic still propagating once all deferred calls have run, or nil if
+// it was recovered.
+func (t *Thread) runDefers(p *PanicError) *PanicError {
+	for len(t.defers) > 0 {
+		d := t.defers[len(t.defers)-1]
+		t.defers = t.defers[0 : len(t.defers)-1]
+		p = t.callDeferred(d, p)
+	}
+	return p
+}
+
+func (t *Thread) callDeferred(d func(*Thread), p *PanicError) (res *PanicError) {
+	f, pc, op, odf := t.f, t.pc, t.panicking, t.deferFrame
+	t.panicking = p
+	defer func() {
+		if r := recover(); r != nil {
+			// A panic in a deferred call replaces the
+			// one propagating.
+			res = toPanicError(r)
+		}
+		t.f, t.pc, t.panicking, t.deferFrame = f, pc, op, odf
+	}()
+	d(t)
+	return t.panicking
+}
+
+// recover stops the panic that the running deferred call may recover
+// and returns its value.  It returns the nil interface if there is no
+// such panic.
+func (t *Thread) recover() Interface {
+	p := t.panicking
+	if p == nil || t.f != t.deferFrame {
+		return Interface{}
+	}
+	t.panicking = nil
+	return p.Value
+}
+
+/*
+ * Methods
+ */
+
+// A boundMethod is a method value; a method bound to the receiver it
+// was selected from.  Its frame holds only the arguments and results;
+// the receiver is passed to the method when it is called.
+type boundMethod struct {
+	m    *Method
+	recv Value
+}
+
+func (f *boundMethod) NewFrame() *Frame {
+	ft := f.m.decl.Type
+	return &Frame{nil, make([]Value, len(ft.In)+len(ft.Out))}
+}
+
+func (f *boundMethod) Call(t *Thread) {
+	ft := f.m.decl.Type
+	nin, nout := len(ft.In), len(ft.Out)
+	fun := f.m.fn
+	fr := fun.NewFrame()
+	// Each call receives its own copy of the receiver.
+	fr.Vars[0] = f.m.recv.Zero()
+	fr.Vars[0].Assign(t, f.recv)
+	copy(fr.Vars[1:], t.f.Vars[0:nin])
+	for i, ot := range ft.Out {
+		fr.Vars[1+nin+i] = ot.Zero()
+	}
+	oldf, odf := t.f, t.deferFrame
+	t.f = fr
+	// A deferred method recovers in its own frame.
+	if odf == oldf {
+		t.deferFrame = fr
+	}
+	fun.Call(t)
+	t.f, t.deferFrame = oldf, odf
+	copy(oldf.Vars[nin:nin+nout], fr.Vars[1+nin:1+nin+nout])
+}
+
+// A derefMethod is the method expression (*T).M for a method M with
+// receiver type T.  It dereferences its first argument before calling
+// the method.
+type derefMethod struct {
+	m *Method
+}
+
+func (f *derefMethod) NewFrame() *Frame { return f.m.fn.NewFrame() }
+
+func (f *derefMethod) Call(t *Thread) {
+	p := t.f.Vars[0].(PtrValue).Get(t)
+	if p == nil {
+		t.Panic(NilPointerError{})
+	}
+	v := f.m.recv.Zero()
+	v.Assign(t, p)
+	t.f.Vars[0] = v
+	f.m.fn.Call(t)
+}
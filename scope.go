@@ -5,8 +5,10 @@
 package chicklet
 
 import (
+	"go/ast"
 	"go/token"
 	"log"
+	"sync"
 )
 
 /*
@@ -95,6 +97,25 @@ type universeScope struct {
 	*Scope
 	// a lookup-table for easy retrieval of packages by their 'path'
 	pkgs map[string]*Scope
+	// mu guards pkgs and the creation of child scopes, which
+	// Worlds on different goroutines share.
+	mu sync.Mutex
+}
+
+// pkg returns the scope of the package with the given path, if it has
+// been imported.
+func (u *universeScope) pkg(path string) (*Scope, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s, ok := u.pkgs[path]
+	return s, ok
+}
+
+// ChildScope returns a new scope nested in the universe.
+func (u *universeScope) ChildScope() *Scope {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.Scope.ChildScope()
 }
 
 func (b *block) enterChild() *block {
@@ -177,7 +198,7 @@ func (b *block) DefineType(name string, pos token.Pos, t Type) Type {
 	if _, ok := b.defs[name]; ok {
 		return nil
 	}
-	nt := &NamedType{pos, name, nil, true, make(map[string]Method)}
+	nt := &NamedType{pos, name, nil, true, make(map[string]*Method)}
 	if t != nil {
 		nt.Complete(t)
 	}
@@ -189,7 +210,8 @@ func (b *block) DefinePackage(id, path string, pos token.Pos) (*PkgIdent, Def) {
 	if prev, ok := b.defs[id]; ok {
 		return nil, prev
 	}
-	p := &PkgIdent{pos, path, universe.pkgs[path]}
+	s, _ := universe.pkg(path)
+	p := &PkgIdent{pos, path, s}
 	b.defs[id] = p
 	return p, nil
 }
@@ -207,6 +229,25 @@ func (b *block) Lookup(name string) (bl *block, level int, def Def) {
 	return nil, 0, nil
 }
 
+// LookupType returns the type named by the qualified identifier x, an
+// exported type of a package imported in b.  ok is false if x does
+// not refer to a package.
+func (b *block) LookupType(x *ast.SelectorExpr) (t Type, ok bool) {
+	id, isIdent := x.X.(*ast.Ident)
+	if !isIdent {
+		return nil, false
+	}
+	_, _, def := b.Lookup(id.Name)
+	pkg, isPkg := def.(*PkgIdent)
+	if !isPkg {
+		return nil, false
+	}
+	if ast.IsExported(x.Sel.Name) {
+		t, _ = pkg.scope.defs[x.Sel.Name].(Type)
+	}
+	return t, true
+}
+
 func (s *Scope) NewFrame(outer *Frame) *Frame { return outer.child(s.maxVars) }
 
 /*
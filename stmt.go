@@ -13,7 +13,9 @@ import (
 	"log"
 	"math/big"
 	"path/filepath"
+	"reflect"
 	"strconv"
+	"unicode/utf8"
 )
 
 const (
@@ -228,7 +230,15 @@ func (a *stmtCompiler) defineVar(ident *ast.Ident, t Type) *Variable {
 	// Initialize the variable
 	index := v.Index
 	if v.Index >= 0 {
-		a.push(func(v *Thread) { v.f.Vars[index] = t.Zero() })
+		a.push(func(v *Thread) {
+			v.alloc(t, 1)
+			v.f.Vars[index] = t.Zero()
+		})
+	} else {
+		a.push(func(th *Thread) {
+			th.alloc(t, 1)
+			v.Init = t.Zero()
+		})
 	}
 	return v
 }
@@ -242,6 +252,47 @@ func (a *stmtCompiler) definePkg(ident ast.Node, id, path string) *PkgIdent {
 	return v
 }
 
+// compileAssignTarget compiles the left side of an assignment,
+// returning an expression with a usable evalAddr, or nil if le is
+// not assignable.
+func (a *stmtCompiler) compileAssignTarget(b *block, le ast.Expr) *expr {
+	l := a.compileExpr(b, false, le)
+	if l == nil {
+		return nil
+	}
+
+	if l.evalMapValue != nil {
+		// Map indexes are not generally addressable,
+		// but they are assignable.
+		//
+		// TODO(austin) Now that the expression
+		// compiler uses semantic values, this might
+		// be easier to implement as a function call.
+		sub := l
+		l = sub.newExpr(sub.t, sub.desc)
+		l.evalMapValue = sub.evalMapValue
+		mvf := sub.evalMapValue
+		et := sub.t
+		l.evalAddr = func(t *Thread) Value {
+			m, k := mvf(t)
+			e := m.Elem(t, k)
+			if e == nil {
+				// Account for the new entry, taking
+				// its key to be one word.
+				t.alloc(et, 1)
+				t.alloc(UintptrType, 1)
+				m.SetElem(t, k, et.Zero())
+				e = m.Elem(t, k)
+			}
+			return e
+		}
+	} else if l.evalAddr == nil {
+		l.diag("cannot assign to %s", l.desc)
+		return nil
+	}
+	return l
+}
+
 // TODO(austin) Move doAssign to here
 
 /*
@@ -252,6 +303,7 @@ func (a *stmtCompiler) compile(s ast.Stmt) {
 	if a.block.inner != nil {
 		log.Panic("Child scope still entered")
 	}
+	a.curPos = s.Pos()
 
 	notimpl := false
 	switch s := s.(type) {
@@ -278,10 +330,13 @@ func (a *stmtCompiler) compile(s ast.Stmt) {
 		a.compileAssignStmt(s)
 
 	case *ast.GoStmt:
-		notimpl = true
+		a.compileGoStmt(s)
+
+	case *ast.SendStmt:
+		a.compileSendStmt(s)
 
 	case *ast.DeferStmt:
-		notimpl = true
+		a.compileDeferStmt(s)
 
 	case *ast.ReturnStmt:
 		a.compileReturnStmt(s)
@@ -302,22 +357,22 @@ func (a *stmtCompiler) compile(s ast.Stmt) {
 		a.compileSwitchStmt(s)
 
 	case *ast.TypeSwitchStmt:
-		notimpl = true
+		a.compileTypeSwitchStmt(s)
 
 	case *ast.CommClause:
-		notimpl = true
+		a.diag("communication clause outside select")
 
 	case *ast.SelectStmt:
-		notimpl = true
+		a.compileSelectStmt(s)
 
 	case *ast.ForStmt:
 		a.compileForStmt(s)
 
 	case *ast.RangeStmt:
-		notimpl = true
+		a.compileRangeStmt(s)
 
 	default:
-		log.Panicf("unexpected ast node type %T", s)
+		notimpl = true
 	}
 
 	if notimpl {
@@ -376,6 +431,103 @@ func (a *stmtCompiler) compileVarDecl(decl *ast.GenDecl) {
 	}
 }
 
+// isConstType returns true if constants may be of type t.
+func isConstType(t Type) bool {
+	switch t.lit().(type) {
+	case *boolType, *uintType, *intType, *floatType, *stringType, *idealIntType, *idealFloatType:
+		return true
+	}
+	return false
+}
+
+func (a *stmtCompiler) compileConstDecl(decl *ast.GenDecl) {
+	// Within a parenthesized const declaration list, an omitted
+	// expression list is equivalent to the preceding one.
+	var typ ast.Expr
+	var values []ast.Expr
+	for iota, spec := range decl.Specs {
+		spec := spec.(*ast.ValueSpec)
+		if spec.Values != nil {
+			typ, values = spec.Type, spec.Values
+		} else if spec.Type != nil || values == nil {
+			a.diagAt(spec.Pos(), "missing value in const declaration")
+			continue
+		}
+		switch {
+		case len(spec.Names) > len(values):
+			a.diagAt(spec.Pos(), "missing value in const declaration")
+			continue
+		case len(spec.Names) < len(values):
+			a.diagAt(values[len(spec.Names)].Pos(), "extra expression in const declaration")
+			continue
+		}
+
+		// iota is only defined while compiling the values,
+		// where it is the index of the spec.
+		bl := a.block.enterChild()
+		bl.DefineConst("iota", token.NoPos, IdealIntType, &idealIntV{big.NewInt(int64(iota))})
+		var t Type
+		if typ != nil {
+			t = a.compileType(bl, typ)
+			if t != nil && !isConstType(t) {
+				a.diagAt(typ.Pos(), "invalid constant type %v", t)
+				t = nil
+			}
+		}
+		vals := make([]*Constant, len(values))
+		for i, v := range values {
+			e := a.compileExpr(bl, true, v)
+			if e == nil || (typ != nil && t == nil) {
+				continue
+			}
+			if !isConstType(e.t) {
+				e.diag("%s is not constant", e.desc)
+				continue
+			}
+			c := &Constant{spec.Names[i].Pos(), e.t, nil}
+			switch {
+			case t != nil:
+				assign := a.compileAssign(v.Pos(), bl, t, []*expr{e}, "const declaration", "value")
+				if assign == nil {
+					continue
+				}
+				c.Type, c.Value = t, t.Zero()
+				if err := new(Thread).Try(func(th *Thread) { assign(c.Value, th) }); err != nil {
+					e.diag("%v", err)
+					continue
+				}
+			case e.t == IdealIntType:
+				c.Value = &idealIntV{e.asIdealInt()()}
+			case e.t == IdealFloatType:
+				c.Value = &idealFloatV{e.asIdealFloat()()}
+			default:
+				c.Value = e.t.Zero()
+				assign := genAssign(e.t, e)
+				if err := new(Thread).Try(func(th *Thread) { assign(c.Value, th) }); err != nil {
+					e.diag("%v", err)
+					continue
+				}
+			}
+			vals[i] = c
+		}
+		bl.exit()
+
+		for i, n := range spec.Names {
+			c := vals[i]
+			if c == nil || n.Name == "_" {
+				continue
+			}
+			if _, prev := a.block.DefineConst(n.Name, c.ConstPos, c.Type, c.Value); prev != nil {
+				if prev.Pos().IsValid() {
+					a.diagAt(n.Pos(), "identifier %s redeclared in this block\n\tprevious declaration at %s", n.Name, a.fset.Position(prev.Pos()))
+				} else {
+					a.diagAt(n.Pos(), "identifier %s redeclared in this block", n.Name)
+				}
+			}
+		}
+	}
+}
+
 func (a *stmtCompiler) compileImportDecl(decl *ast.GenDecl) {
 	for _, spec := range decl.Specs {
 		spec := spec.(*ast.ImportSpec)
@@ -384,6 +536,10 @@ func (a *stmtCompiler) compileImportDecl(decl *ast.GenDecl) {
 		if spec.Name != nil {
 			n = spec.Name.Name
 		}
+		if err := a.spec.importError(path); err != nil {
+			a.diagAt(spec.Pos(), "%v", err)
+			continue
+		}
 		//FIXME: this 'imports' object should be a member of stmtCompiler
 		//       (or even to 'compiler' ?)
 		imports := make(map[string]*ast.Object)
@@ -409,6 +565,10 @@ func (a *stmtCompiler) compileDecl(decl ast.Decl) {
 		a.silentErrors++
 
 	case *ast.FuncDecl:
+		if d.Recv != nil {
+			a.compileMethodDecl(d)
+			return
+		}
 		//fmt.Printf("...compileDecl(%s)...\n", d.Name.Name)
 		decl := a.compileFuncType(a.block, d.Type)
 		if decl == nil {
@@ -437,7 +597,7 @@ func (a *stmtCompiler) compileDecl(decl ast.Decl) {
 		case token.IMPORT:
 			a.compileImportDecl(d)
 		case token.CONST:
-			log.Panicf("%v not implemented", d.Tok)
+			a.compileConstDecl(d)
 		case token.TYPE:
 			a.compileTypeDecl(a.block, d)
 		case token.VAR:
@@ -450,6 +610,86 @@ func (a *stmtCompiler) compileDecl(decl ast.Decl) {
 
 }
 
+func (a *stmtCompiler) compileMethodDecl(d *ast.FuncDecl) {
+	if len(d.Recv.List) != 1 || len(d.Recv.List[0].Names) > 1 {
+		a.diagAt(d.Recv.Pos(), "method has multiple receivers")
+		return
+	}
+	field := d.Recv.List[0]
+	var recvName *ast.Ident
+	if len(field.Names) == 1 {
+		recvName = field.Names[0]
+	}
+
+	// The receiver must be a named type T or a pointer to it,
+	// where T is declared by the script and is not itself a
+	// pointer or interface type.
+	recv := a.compileType(a.block, field.Type)
+	if recv == nil {
+		return
+	}
+	nt, ok := recv.(*NamedType)
+	if pt, isPtr := recv.(*PtrType); isPtr {
+		nt, ok = pt.Elem.(*NamedType)
+	}
+	if !ok {
+		a.diagAt(field.Type.Pos(), "invalid receiver type %v", recv)
+		return
+	}
+	if !nt.NamePos.IsValid() {
+		a.diagAt(field.Type.Pos(), "cannot define new methods on non-local type %v", nt)
+		return
+	}
+	switch nt.lit().(type) {
+	case *PtrType, *InterfaceType:
+		a.diagAt(field.Type.Pos(), "invalid receiver type %v (pointer or interface type)", recv)
+		return
+	}
+
+	name := d.Name.Name
+	if _, ok := nt.methods[name]; ok {
+		a.diagAt(d.Name.Pos(), "method %v.%s redeclared", nt, name)
+		return
+	}
+	if st, ok := nt.lit().(*StructType); ok {
+		for _, f := range st.Elems {
+			if f.Name == name {
+				a.diagAt(d.Name.Pos(), "type %v has both field and method named %s", nt, name)
+				return
+			}
+		}
+	}
+
+	decl := a.compileFuncType(a.block, d.Type)
+	if decl == nil {
+		return
+	}
+	decl.Name = d.Name
+
+	// Register the method before compiling its body so the body
+	// can refer to it.
+	m := &Method{decl: decl, recv: recv}
+	nt.methods[name] = m
+
+	// The method is implemented by a function taking the
+	// receiver as its first argument.
+	in := make([]Type, len(decl.Type.In)+1)
+	in[0] = recv
+	copy(in[1:], decl.Type.In)
+	inNames := make([]*ast.Ident, len(decl.InNames)+1)
+	inNames[0] = recvName
+	copy(inNames[1:], decl.InNames)
+	fdecl := &FuncDecl{NewFuncType(in, decl.Type.Variadic, decl.Type.Out), d.Name, inNames, decl.OutNames}
+
+	fn := a.compileFunc(a.block, fdecl, d.Body)
+	if fn == nil {
+		delete(nt.methods, name)
+		return
+	}
+	var zeroThread Thread
+	m.fn = fn(&zeroThread)
+}
+
 func (a *stmtCompiler) compileLabeledStmt(s *ast.LabeledStmt) {
 	// Define label
 	l, ok := a.labels[s.Label.Name]
@@ -490,9 +730,133 @@ func (a *stmtCompiler) compileExprStmt(s *ast.ExprStmt) {
 		return
 	}
 
+	// A call to panic never returns.
+	if isPanicCall(bc.block, s.X) {
+		a.flow.putTerm()
+	}
 	a.push(e.exec)
 }
 
+// isPanicCall returns true if x is a call of the built-in panic
+// function.
+func isPanicCall(b *block, x ast.Expr) bool {
+	call, ok := x.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	id, ok := call.Fun.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	_, _, def := b.Lookup(id.Name)
+	c, ok := def.(*Constant)
+	return ok && c.Type == Type(panicType)
+}
+
+func (a *stmtCompiler) compileGoStmt(s *ast.GoStmt) {
+	if a.spec.Policy.NoGoroutines {
+		a.diag("go statements are not allowed")
+		return
+	}
+	bc := a.enterChild()
+	defer bc.exit()
+
+	e := a.compileExpr(bc.block, false, s.Call)
+	if e == nil {
+		return
+	}
+
+	switch {
+	case e.evalCall != nil:
+		cf := e.evalCall
+		a.push(func(t *Thread) {
+			fun, fr := cf(t)
+			go t.spawn(fr).run(fun.Call)
+		})
+	case e.exec != nil:
+		// Built-in functions evaluate their arguments in the
+		// new goroutine.
+		exec := e.exec
+		a.push(func(t *Thread) { go t.spawn(t.f).run(exec) })
+	default:
+		a.diag("%s cannot be called in a goroutine", e.desc)
+	}
+}
+
+func (a *stmtCompiler) compileSendStmt(s *ast.SendStmt) {
+	bc := a.enterChild()
+	defer bc.exit()
+
+	c := a.compileExpr(bc.block, false, s.Chan)
+	v := a.compileExpr(bc.block, false, s.Value)
+	if c == nil || v == nil {
+		return
+	}
+
+	ct, ok := c.t.lit().(*ChanType)
+	if !ok {
+		c.diag("cannot send to non-channel %v", c.t)
+		return
+	}
+	if ct.Dir == ast.RECV {
+		c.diag("cannot send to receive-only channel %v", c.t)
+		return
+	}
+
+	assign := a.compileAssign(s.Arrow, bc.block, ct.Elem, []*expr{v}, "send", "value")
+	if assign == nil {
+		return
+	}
+
+	cf := c.asChan()
+	et := ct.Elem
+	a.push(func(t *Thread) {
+		ch := cf(t)
+		v := et.Zero()
+		assign(v, t)
+		if ch == nil {
+			// Sending to a nil channel blocks forever.
+			t.block()
+		}
+		ch.Send(t, v)
+	})
+}
+
+func (a *stmtCompiler) compileDeferStmt(s *ast.DeferStmt) {
+	if a.fnType == nil {
+		a.diag("cannot defer at the top level")
+		return
+	}
+
+	bc := a.enterChild()
+	defer bc.exit()
+
+	e := a.compileExpr(bc.block, false, s.Call)
+	if e == nil {
+		return
+	}
+
+	switch {
+	case e.evalCall != nil:
+		cf := e.evalCall
+		a.push(func(t *Thread) {
+			fun, fr := cf(t)
+			t.defers = append(t.defers, func(t *Thread) {
+				t.f = fr
+				t.deferFrame = fr
+				fun.Call(t)
+			})
+		})
+	case e.exec != nil:
+		// Built-in functions evaluate their arguments when
+		// the deferred call runs.
+		exec := e.exec
+		a.push(func(t *Thread) { t.defers = append(t.defers, exec) })
+	default:
+		a.diag("%s cannot be deferred", e.desc)
+	}
+}
+
 func (a *stmtCompiler) compileIncDecStmt(s *ast.IncDecStmt) {
 	// Create temporary block for extractEffect
 	bc := a.enterChild()
@@ -630,6 +994,10 @@ func (a *stmtCompiler) doAssign(lhs []ast.Expr, rhs []ast.Expr, tok token.Token,
 				// the RHS.
 				lt = nil
 
+			case ac.rmt.Elems[i] == NilType:
+				a.diagAt(lhs[i].Pos(), "use of untyped nil")
+				lt = nil
+
 			case ac.rmt.Elems[i].isIdeal():
 				// If the type is absent and the
 				// corresponding expression is a
@@ -659,36 +1027,7 @@ func (a *stmtCompiler) doAssign(lhs []ast.Expr, rhs []ast.Expr, tok token.Token,
 		}
 
 		// Compile LHS
-		ls[i] = a.compileExpr(a.block, false, le)
-		if ls[i] == nil {
-			continue
-		}
-
-		if ls[i].evalMapValue != nil {
-			// Map indexes are not generally addressable,
-			// but they are assignable.
-			//
-			// TODO(austin) Now that the expression
-			// compiler uses semantic values, this might
-			// be easier to implement as a function call.
-			sub := ls[i]
-			ls[i] = ls[i].newExpr(sub.t, sub.desc)
-			ls[i].evalMapValue = sub.evalMapValue
-			mvf := sub.evalMapValue
-			et := sub.t
-			ls[i].evalAddr = func(t *Thread) Value {
-				m, k := mvf(t)
-				e := m.Elem(t, k)
-				if e == nil {
-					e = et.Zero()
-					m.SetElem(t, k, e)
-				}
-				return e
-			}
-		} else if ls[i].evalAddr == nil {
-			ls[i].diag("cannot assign to %s", ls[i].desc)
-			continue
-		}
+		ls[i] = a.compileAssignTarget(a.block, le)
 	}
 
 	// A short variable declaration may redeclare variables
@@ -933,6 +1272,10 @@ func (a *stmtCompiler) compileBranchStmt(s *ast.BranchStmt) {
 
 	case token.GOTO:
 		l, ok := a.labels[s.Label.Name]
+		if ok && l.resolved.IsValid() && a.spec.Policy.NoUnboundedLoops {
+			a.diag("backward goto statements are not allowed")
+			return
+		}
 		if !ok {
 			pc := badPC
 			l = &label{name: s.Label.Name, desc: "unresolved label", gotoPC: &pc, used: s.Pos()}
@@ -1107,6 +1450,14 @@ func (a *stmtCompiler) compileSwitchStmt(s *ast.SwitchStmt) {
 	condbc.exit()
 
 	// Compile cases
+	body := bc.enterChild()
+	if a.stmtLabel != nil {
+		body.label = a.stmtLabel
+	} else {
+		body.label = &label{resolved: s.Pos()}
+	}
+	body.label.desc = "switch"
+	body.label.breakPC = &endPC
 	i = 0
 	for _, c := range s.Body.List {
 		clause, ok := c.(*ast.CaseClause)
@@ -1146,7 +1497,7 @@ func (a *stmtCompiler) compileSwitchStmt(s *ast.SwitchStmt) {
 				}
 				fall = true
 			} else {
-				bc.compileStmt(s)
+				body.compileStmt(s)
 			}
 		}
 		// Jump out of switch, unless there was a fallthrough
@@ -1155,15 +1506,462 @@ func (a *stmtCompiler) compileSwitchStmt(s *ast.SwitchStmt) {
 			a.push(func(v *Thread) { v.pc = endPC })
 		}
 	}
+	body.exit()
+
+	// Get end PC
+	endPC = a.nextPC()
+	if !hasDefault {
+		casePCs[ncases] = &endPC
+	}
+}
+
+func (a *stmtCompiler) compileTypeSwitchStmt(s *ast.TypeSwitchStmt) {
+	// Create implicit scope around switch
+	bc := a.enterChild()
+	defer bc.exit()
+
+	// Compile init statement, if any
+	if s.Init != nil {
+		bc.compileStmt(s.Init)
+	}
+
+	// Find the guard expression and the symbol, if any
+	var ident *ast.Ident
+	var guard ast.Expr
+	switch as := s.Assign.(type) {
+	case *ast.ExprStmt:
+		guard = as.X
+	case *ast.AssignStmt:
+		ident = as.Lhs[0].(*ast.Ident)
+		guard = as.Rhs[0]
+	}
+	ta, ok := guard.(*ast.TypeAssertExpr)
+	if !ok || ta.Type != nil {
+		a.diagAt(guard.Pos(), "type switch must use x.(type)")
+		return
+	}
+
+	// Evaluate the guard into a temporary
+	x := bc.compileExpr(bc.block, false, ta.X)
+	if x == nil {
+		return
+	}
+	it, ok := x.t.lit().(*InterfaceType)
+	if !ok {
+		x.diag("cannot type switch on non-interface value %v", x.t)
+		return
+	}
+	xf := x.asIface()
+	xIdx := bc.block.DefineTemp(x.t).Index
+	a.push(func(t *Thread) { t.f.Vars[xIdx] = &interfaceV{xf(t)} })
+
+	// Compile case types.  A nil type stands for the nil case.
+	type typeCase struct {
+		types []Type
+		isNil []bool
+	}
+	ncases := 0
+	hasDefault := false
+	cases := make([]typeCase, len(s.Body.List))
+	for ci, c := range s.Body.List {
+		clause, ok := c.(*ast.CaseClause)
+		if !ok {
+			a.diagAt(c.Pos(), "switch statement must contain case clauses")
+			continue
+		}
+		if clause.List == nil {
+			if hasDefault {
+				a.diagAt(clause.Pos(), "switch statement contains more than one default case")
+			}
+			hasDefault = true
+			continue
+		}
+		for _, e := range clause.List {
+			if id, ok := e.(*ast.Ident); ok && id.Name == "nil" {
+				if _, _, def := bc.block.Lookup("nil"); def != nil && def.(*Constant).Type == NilType {
+					cases[ci].types = append(cases[ci].types, nil)
+					cases[ci].isNil = append(cases[ci].isNil, true)
+					ncases++
+					continue
+				}
+			}
+			ct := bc.compileType(bc.block, e)
+			if ct != nil {
+				if _, isIface := ct.lit().(*InterfaceType); !isIface {
+					if m, ok := it.implementedBy(ct); !ok {
+						a.diagAt(e.Pos(), "impossible type switch case: %v cannot have dynamic type %v (missing %s method)", x.t, ct, m.Name)
+					}
+				}
+			}
+			cases[ci].types = append(cases[ci].types, ct)
+			cases[ci].isNil = append(cases[ci].isNil, false)
+			ncases++
+		}
+	}
+
+	// Build the matchers, in source order
+	matchers := make([]func(Interface) bool, 0, ncases)
+	for _, tc := range cases {
+		for i, ct := range tc.types {
+			switch {
+			case tc.isNil[i]:
+				matchers = append(matchers, func(v Interface) bool { return v.Type == nil })
+			case ct == nil:
+				// Error already reported
+				matchers = append(matchers, func(Interface) bool { return false })
+			default:
+				if cit, ok := ct.lit().(*InterfaceType); ok {
+					matchers = append(matchers, func(v Interface) bool {
+						if v.Type == nil {
+							return false
+						}
+						_, ok := cit.implementedBy(v.Type)
+						return ok
+					})
+				} else {
+					ct := ct
+					matchers = append(matchers, func(v Interface) bool { return v.Type != nil && ct.compat(v.Type, false) })
+				}
+			}
+		}
+	}
+
+	// Emit condition
+	casePCs := make([]*uint, ncases+1)
+	endPC := badPC
+
+	a.flow.put(false, false, casePCs)
+	a.push(func(t *Thread) {
+		v := t.f.Vars[xIdx].(InterfaceValue).Get(t)
+		for i, m := range matchers {
+			if m(v) {
+				t.pc = *casePCs[i]
+				return
+			}
+		}
+		t.pc = *casePCs[ncases]
+	})
+
+	// Compile cases
+	body := bc.enterChild()
+	if a.stmtLabel != nil {
+		body.label = a.stmtLabel
+	} else {
+		body.label = &label{resolved: s.Pos()}
+	}
+	body.label.desc = "switch"
+	body.label.breakPC = &endPC
+	i := 0
+	for ci, c := range s.Body.List {
+		clause, ok := c.(*ast.CaseClause)
+		if !ok {
+			continue
+		}
+
+		// Save jump PC's
+		pc := a.nextPC()
+		if clause.List != nil {
+			for _ = range clause.List {
+				casePCs[i] = &pc
+				i++
+			}
+		} else {
+			// Default clause
+			casePCs[ncases] = &pc
+		}
+
+		// Each clause is its own scope
+		cbc := body.enterChild()
+		if ident != nil {
+			// In clauses with a case listing exactly one
+			// type, the variable has that type;
+			// otherwise, the variable has the type of the
+			// guard expression.
+			vt := x.t
+			tc := cases[ci]
+			if len(tc.types) == 1 && !tc.isNil[0] && tc.types[0] != nil {
+				vt = tc.types[0]
+			}
+			sc := &stmtCompiler{cbc, ident.Pos(), nil}
+			if v := sc.defineVar(ident, vt); v != nil {
+				idx := v.Index
+				if _, ok := vt.lit().(*InterfaceType); ok {
+					sc.push(func(t *Thread) {
+						t.f.Vars[idx].Assign(t, t.f.Vars[xIdx])
+					})
+				} else {
+					sc.push(func(t *Thread) {
+						t.f.Vars[idx].Assign(t, t.f.Vars[xIdx].(InterfaceValue).Get(t).Value)
+					})
+				}
+			}
+		}
+		for _, s := range clause.Body {
+			cbc.compileStmt(s)
+		}
+		cbc.exit()
+
+		// Jump out of switch
+		a.flow.put1(false, &endPC)
+		a.push(func(v *Thread) { v.pc = endPC })
+	}
+	body.exit()
+
+	// Get end PC
+	endPC = a.nextPC()
+	if !hasDefault {
+		casePCs[ncases] = &endPC
+	}
+}
+
+func (a *stmtCompiler) compileSelectStmt(s *ast.SelectStmt) {
+	// Create implicit scope around select
+	bc := a.enterChild()
+	defer bc.exit()
+
+	// Compile the communication of each case.  The channel
+	// operands and values to send are all evaluated, in source
+	// order, when the select statement is entered.
+	type commCase struct {
+		clause *ast.CommClause
+		// The channel element type, and the left side and
+		// token of a receive with assignment.
+		elem Type
+		lhs  []ast.Expr
+		tok  token.Token
+	}
+	var comms []commCase
+	var evals []func(*Thread) reflect.SelectCase
+	hasDefault := false
+	for _, c := range s.Body.List {
+		clause, ok := c.(*ast.CommClause)
+		if !ok {
+			a.diagAt(c.Pos(), "select statement must contain communication clauses")
+			continue
+		}
+		if clause.Comm == nil {
+			if hasDefault {
+				a.diagAt(clause.Pos(), "select statement contains more than one default case")
+			}
+			hasDefault = true
+			continue
+		}
+
+		cc := commCase{clause: clause}
+		var recv ast.Expr
+		switch comm := clause.Comm.(type) {
+		case *ast.SendStmt:
+			ch := bc.compileExpr(bc.block, false, comm.Chan)
+			v := bc.compileExpr(bc.block, false, comm.Value)
+			if ch == nil || v == nil {
+				continue
+			}
+			ct, ok := ch.t.lit().(*ChanType)
+			if !ok {
+				ch.diag("cannot send to non-channel %v", ch.t)
+				continue
+			}
+			if ct.Dir == ast.RECV {
+				ch.diag("cannot send to receive-only channel %v", ch.t)
+				continue
+			}
+			assign := a.compileAssign(comm.Arrow, bc.block, ct.Elem, []*expr{v}, "send", "value")
+			if assign == nil {
+				continue
+			}
+			cf := ch.asChan()
+			et := ct.Elem
+			evals = append(evals, func(t *Thread) reflect.SelectCase {
+				v := et.Zero()
+				assign(v, t)
+				return chanSelectCase(t, cf(t), v)
+			})
+			comms = append(comms, cc)
+			continue
+
+		case *ast.ExprStmt:
+			recv = comm.X
+
+		case *ast.AssignStmt:
+			if len(comm.Lhs) > 2 || len(comm.Rhs) != 1 {
+				a.diagAt(comm.Pos(), "select case must be receive, send or assign recv")
+				continue
+			}
+			cc.lhs, cc.tok = comm.Lhs, comm.Tok
+			recv = comm.Rhs[0]
+
+		default:
+			a.diagAt(clause.Comm.Pos(), "select case must be receive, send or assign recv")
+			continue
+		}
+
+		x, ok := recv.(*ast.UnaryExpr)
+		if !ok || x.Op != token.ARROW {
+			a.diagAt(recv.Pos(), "select case must be receive, send or assign recv")
+			continue
+		}
+		ch := bc.compileExpr(bc.block, false, x.X)
+		if ch == nil {
+			continue
+		}
+		ct, ok := ch.t.lit().(*ChanType)
+		if !ok {
+			ch.diag("cannot receive from non-channel %v", ch.t)
+			continue
+		}
+		if ct.Dir == ast.SEND {
+			ch.diag("cannot receive from send-only channel %v", ch.t)
+			continue
+		}
+		cf := ch.asChan()
+		evals = append(evals, func(t *Thread) reflect.SelectCase { return chanSelectCase(t, cf(t), nil) })
+		cc.elem = ct.Elem
+		comms = append(comms, cc)
+	}
+
+	// The received value and whether it was delivered by a send
+	// live in a temporary for the chosen case to read.
+	ncases := len(comms)
+	resIdx := bc.block.DefineTemp(nil).Index
+	casePCs := make([]*uint, ncases+1)
+	endPC := badPC
+
+	elems := make([]Type, ncases)
+	for i, cc := range comms {
+		elems[i] = cc.elem
+	}
+	a.flow.put(false, false, casePCs)
+	a.push(func(t *Thread) {
+		cases := make([]reflect.SelectCase, len(evals), len(evals)+2)
+		for i, eval := range evals {
+			cases[i] = eval(t)
+		}
+		if hasDefault {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+		}
+		// Waiting is cut short if the run is cancelled.
+		cancel := -1
+		if done := t.done(); done != nil {
+			cancel = len(cases)
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+		}
+		chosen, recv, ok := reflect.Select(cases)
+		if chosen == cancel {
+			t.Abort(t.limits.ctx.Err())
+		}
+		if chosen < ncases && elems[chosen] != nil {
+			var v Value
+			if ok {
+				v = recv.Interface().(Value)
+			} else {
+				v = elems[chosen].Zero()
+			}
+			found := boolV(ok)
+			t.f.Vars[resIdx] = multiV([]Value{v, &found})
+		}
+		t.pc = *casePCs[chosen]
+	})
+
+	// Compile cases
+	body := bc.enterChild()
+	if a.stmtLabel != nil {
+		body.label = a.stmtLabel
+	} else {
+		body.label = &label{resolved: s.Pos()}
+	}
+	body.label.desc = "select"
+	body.label.breakPC = &endPC
+	i := 0
+	for _, c := range s.Body.List {
+		clause, ok := c.(*ast.CommClause)
+		if !ok {
+			continue
+		}
+
+		pc := a.nextPC()
+		cc := commCase{}
+		if clause.Comm == nil {
+			casePCs[ncases] = &pc
+		} else if i < ncases && comms[i].clause == clause {
+			casePCs[i] = &pc
+			cc = comms[i]
+			i++
+		} else {
+			// The communication failed to compile.
+			continue
+		}
+
+		// Each clause is its own scope.
+		cbc := body.enterChild()
+		cbc.compileRecvAssign(cc.lhs, cc.tok, cc.elem, resIdx)
+		for _, s := range clause.Body {
+			cbc.compileStmt(s)
+		}
+		cbc.exit()
+
+		// Jump out of select
+		a.flow.put1(false, &endPC)
+		a.push(func(v *Thread) { v.pc = endPC })
+	}
+	body.exit()
 
 	// Get end PC
 	endPC = a.nextPC()
 	if !hasDefault {
+		// Unreachable, since reflect.Select blocks.
 		casePCs[ncases] = &endPC
 	}
 }
 
+// compileRecvAssign compiles the assignment of the value received by a
+// select case, and whether it was delivered by a send, to lhs.  The
+// values are read from the temporary at index resIdx.
+func (a *blockCompiler) compileRecvAssign(lhs []ast.Expr, tok token.Token, elem Type, resIdx int) {
+	nDefs := 0
+	for i, e := range lhs {
+		id, ok := e.(*ast.Ident)
+		if ok && id.Name == "_" {
+			continue
+		}
+		t := elem
+		if i == 1 {
+			t = BoolType
+		}
+		sc := &stmtCompiler{a, e.Pos(), nil}
+		if tok == token.DEFINE {
+			if !ok {
+				a.diagAt(e.Pos(), "left side of := must be a name")
+				continue
+			}
+			if sc.defineVar(id, t) == nil {
+				continue
+			}
+			nDefs++
+		}
+		l := sc.compileAssignTarget(a.block, e)
+		if l == nil {
+			continue
+		}
+		index := i
+		r := l.newExpr(t, "receive value")
+		r.genValue(func(t *Thread) Value { return t.f.Vars[resIdx].(multiV)[index] })
+		assign := a.compileAssign(e.Pos(), a.block, l.t, []*expr{r}, "assignment", "value")
+		if assign == nil {
+			continue
+		}
+		lf := l.evalAddr
+		sc.push(func(t *Thread) { assign(lf(t), t) })
+	}
+	if tok == token.DEFINE && nDefs == 0 && len(lhs) > 0 {
+		a.diagAt(lhs[0].Pos(), "no new variables on left side of :=")
+	}
+}
+
 func (a *stmtCompiler) compileForStmt(s *ast.ForStmt) {
+	if s.Cond == nil && a.spec.Policy.NoUnboundedLoops {
+		a.diag("for loops without a condition are not allowed")
+		return
+	}
 	// Wrap the entire for in a block.
 	bc := a.enterChild()
 	defer bc.exit()
@@ -1218,6 +2016,8 @@ func (a *stmtCompiler) compileForStmt(s *ast.ForStmt) {
 			// Error reported by compileExpr
 		case !e.t.isBoolean():
 			a.diag("'for' condition must be boolean\n\t%v", e.t)
+		case a.spec.Policy.NoUnboundedLoops && isConstantExpr(bc.block, s.Cond) && e.asBool()(nil):
+			a.diag("for loops with a constant true condition are not allowed")
 		default:
 			eval := e.asBool()
 			a.flow.put1(true, &bodyPC)
@@ -1232,6 +2032,250 @@ func (a *stmtCompiler) compileForStmt(s *ast.ForStmt) {
 	endPC = a.nextPC()
 }
 
+// isConstantExpr returns true if x is built only from literals and
+// constants, so that it evaluates to the same value every time.
+func isConstantExpr(b *block, x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		_, _, def := b.Lookup(x.Name)
+		c, ok := def.(*Constant)
+		return ok && isConstType(c.Type)
+	case *ast.ParenExpr:
+		return isConstantExpr(b, x.X)
+	case *ast.UnaryExpr:
+		return isConstantExpr(b, x.X)
+	case *ast.BinaryExpr:
+		return isConstantExpr(b, x.X) && isConstantExpr(b, x.Y)
+	}
+	return false
+}
+
+// compileRangeIter type checks the range expression of a for range
+// statement.  It returns the types of the iteration values and a
+// function that, evaluated once on loop entry, creates the iterator.
+func (a *stmtCompiler) compileRangeIter(x *expr) (kt, vt Type, start func(*Thread) *rangeV) {
+	x = x.derefArray()
+	switch xt := x.t.lit().(type) {
+	case *ArrayType:
+		// The range expression is evaluated once; for an
+		// array this means iterating over a copy.
+		xf := x.asArray()
+		return IntType, xt.Elem, func(t *Thread) *rangeV {
+			arr := xt.Zero()
+			arr.Assign(t, xf(t))
+			return sliceRange(NewSliceType(xt.Elem), Slice{arr.(ArrayValue), xt.Len, xt.Len})
+		}
+
+	case *SliceType:
+		xf := x.asSlice()
+		return IntType, xt.Elem, func(t *Thread) *rangeV { return sliceRange(xt, xf(t)) }
+
+	case *stringType:
+		xf := x.asString()
+		return IntType, Int32Type, func(t *Thread) *rangeV {
+			s := xf(t)
+			it := &rangeV{}
+			i := 0
+			it.next = func(t *Thread) bool {
+				if i >= len(s) {
+					return false
+				}
+				r, size := utf8.DecodeRuneInString(s[i:])
+				k := intV(i)
+				v := int32V(r)
+				it.key, it.val = &k, &v
+				i += size
+				return true
+			}
+			return it
+		}
+
+	case *MapType:
+		xf := x.asMap()
+		return xt.Key, xt.Elem, func(t *Thread) *rangeV {
+			m := xf(t)
+			var keys []interface{}
+			if m != nil {
+				m.Iter(func(k interface{}, _ Value) bool {
+					keys = append(keys, k)
+					return true
+				})
+			}
+			it := &rangeV{}
+			it.next = func(t *Thread) bool {
+				for len(keys) > 0 {
+					k := keys[0]
+					keys = keys[1:]
+					// Entries removed during iteration
+					// are not produced.
+					if v := m.Elem(t, k); v != nil {
+						it.key, it.val = mapKeyValue(t, xt.Key, k), v
+						return true
+					}
+				}
+				return false
+			}
+			return it
+		}
+
+	case *ChanType:
+		if xt.Dir == ast.SEND {
+			break
+		}
+		xf := x.asChan()
+		return xt.Elem, nil, func(t *Thread) *rangeV {
+			c := xf(t)
+			it := &rangeV{}
+			it.next = func(t *Thread) bool {
+				if c == nil {
+					t.block()
+				}
+				v, ok := c.Recv(t)
+				it.key = v
+				return ok
+			}
+			return it
+		}
+	}
+
+	x.diag("cannot range over %v", x.t)
+	return nil, nil, nil
+}
+
+func sliceRange(st *SliceType, s Slice) *rangeV {
+	it := &rangeV{}
+	i := int64(-1)
+	it.next = func(t *Thread) bool {
+		i++
+		if i >= s.Len {
+			return false
+		}
+		k := intV(i)
+		it.key, it.val = &k, s.Base.Elem(t, i)
+		return true
+	}
+	return it
+}
+
+func (a *stmtCompiler) compileRangeStmt(s *ast.RangeStmt) {
+	// Wrap the entire range in a block.
+	bc := a.enterChild()
+	defer bc.exit()
+
+	x := bc.compileExpr(bc.block, false, s.X)
+	if x == nil {
+		return
+	}
+	if _, ok := x.t.lit().(*ChanType); ok && a.spec.Policy.NoUnboundedLoops {
+		a.diag("range over channels is not allowed")
+		return
+	}
+	kt, vt, start := a.compileRangeIter(x)
+	if start == nil {
+		return
+	}
+
+	// The iterator lives in a temporary so each execution of the
+	// statement gets its own.
+	itIdx := bc.block.DefineTemp(nil).Index
+	a.push(func(t *Thread) { t.f.Vars[itIdx] = start(t) })
+
+	// Compile the iteration variables.  With :=, they are
+	// declared once and reused by every iteration.
+	isBlank := func(e ast.Expr) bool {
+		id, ok := e.(*ast.Ident)
+		return e == nil || (ok && id.Name == "_")
+	}
+	type rangeVar struct {
+		lhs *expr
+		t   Type
+		get func(*rangeV) Value
+	}
+	var vars []rangeVar
+	nerr := a.numError()
+	for i, e := range []ast.Expr{s.Key, s.Value} {
+		if isBlank(e) {
+			continue
+		}
+		rv := rangeVar{t: kt, get: func(it *rangeV) Value { return it.key }}
+		if i == 1 && vt == nil {
+			a.diagAt(e.Pos(), "range over %v permits only one iteration variable", x.t)
+			continue
+		}
+		if i == 1 {
+			rv = rangeVar{t: vt, get: func(it *rangeV) Value { return it.val }}
+		}
+		if s.Tok == token.DEFINE {
+			ident, ok := e.(*ast.Ident)
+			if !ok {
+				a.diagAt(e.Pos(), "left side of := must be a name")
+				continue
+			}
+			sc := &stmtCompiler{bc, ident.Pos(), nil}
+			if sc.defineVar(ident, rv.t) == nil {
+				continue
+			}
+		}
+		rv.lhs = a.compileAssignTarget(bc.block, e)
+		vars = append(vars, rv)
+	}
+	if s.Tok == token.DEFINE && len(vars) == 0 && s.Key != nil && nerr == a.numError() {
+		a.diag("no new variables on left side of :=")
+	}
+	if nerr != a.numError() {
+		return
+	}
+
+	assigns := make([]func(*Thread), len(vars))
+	for i, rv := range vars {
+		get := rv.get
+		r := x.newExpr(rv.t, "range value")
+		r.genValue(func(t *Thread) Value { return get(t.f.Vars[itIdx].(*rangeV)) })
+		assign := a.compileAssign(s.Pos(), bc.block, rv.lhs.t, []*expr{r}, "range", "value")
+		if assign == nil {
+			return
+		}
+		lf := rv.lhs.evalAddr
+		assigns[i] = func(t *Thread) { assign(lf(t), t) }
+	}
+
+	nextPC := badPC
+	endPC := badPC
+
+	// Advance the iterator, leaving the loop when it's exhausted.
+	nextPC = a.nextPC()
+	a.flow.put1(true, &endPC)
+	a.push(func(t *Thread) {
+		if !t.f.Vars[itIdx].(*rangeV).next(t) {
+			t.pc = endPC
+			return
+		}
+		for _, assign := range assigns {
+			assign(t)
+		}
+	})
+
+	// Compile body
+	body := bc.enterChild()
+	if a.stmtLabel != nil {
+		body.label = a.stmtLabel
+	} else {
+		body.label = &label{resolved: s.Pos()}
+	}
+	body.label.desc = "for loop"
+	body.label.breakPC = &endPC
+	body.label.continuePC = &nextPC
+	body.compileStmts(s.Body)
+	body.exit()
+
+	a.flow.put1(false, &nextPC)
+	a.push(func(t *Thread) { t.pc = nextPC })
+
+	endPC = a.nextPC()
+}
+
 /*
  * Block compiler
  */
@@ -1371,6 +2415,12 @@ func srcImporter(imports map[string]*ast.Object, path string) (pkg *ast.Object,
 		return nil, nil // package was imported before
 	}
 
+	if native, ok := nativePackages[path]; ok {
+		pkg = ast.NewObj(ast.Pkg, native.Name)
+		imports[path] = pkg
+		return pkg, nil
+	}
+
 	buildPkg, err := build.Import(path, "", 0)
 	if err != nil {
 		return nil, err
@@ -4,10 +4,21 @@
 
 package chicklet
 
-import "testing"
+import (
+	"math/big"
+	"testing"
+)
 
 var atLeastOneDecl = "at least one new variable must be declared"
 
+// Declarations shared by the method, interface and variadic function
+// tests.
+var (
+	methodDecls = "type P struct { x int }; func (p P) Get() int { return p.x }; func (p *P) Set(v int) { p.x = v }; func (p P) Bump() { p.x++ }"
+	shapeDecls  = "type Shape interface { Area() int }; type Sq struct { s int }; func (q Sq) Area() int { return q.s * q.s }; type Rect struct { w, h int }; func (r *Rect) Area() int { return r.w * r.h }"
+	sumDecl     = "func sum(base int, xs ...int) int { for _, x := range xs { base += x }; return base }"
+)
+
 var stmtTests = []test{
 	// Short declarations
 	Val1("x := i", "x", 1),
@@ -65,6 +76,10 @@ var stmtTests = []test{
 	CErr("type ST []int; type AT *[2]int; var x AT = &ai; var y ST = x", opTypes),
 	Run("type ST []int; var y ST = &ai"),
 	Run("type AT *[2]int; var x AT = &ai; var y []int = x"),
+	CErr("s = 1", "cannot use ideal integer as"),
+	CErr("var p *int = 1.5", "cannot use ideal float as"),
+	CErr("x := []string{1}", "cannot convert literal 1"),
+	CErr("var x interface{}; y := x{}", "not a type"),
 
 	// Op-assignment
 	Val1("i += 2", "i", 3),
@@ -78,6 +93,33 @@ var stmtTests = []test{
 	// Single evaluation
 	Val2("ai[func()int{i+=1;return 0}()] *= 3; i2 = ai[0]", "i", 2, "i2", 3),
 
+	// Constant declarations
+	Val1("const k = 4", "k", big.NewInt(4)),
+	Val1("const k uint8 = 4", "k", uint8(4)),
+	Val1("const k, l = 2, 1.5", "l", big.NewRat(3, 2)),
+	Val1("const k = c * 2 + 1", "k", big.NewInt(3)),
+	Val1("const k = \"x\" + \"y\"", "k", "xy"),
+	Val1("const k = 1 << 100 >> 98", "k", big.NewInt(4)),
+	Val1("const ( A = iota; B; C )", "C", big.NewInt(2)),
+	Val1("const ( A = 1 << iota; B; C; D )", "D", big.NewInt(8)),
+	Val1("const ( A, B = iota, iota * 10; C, D )", "D", big.NewInt(10)),
+	Val1("const ( A uint8 = iota + 1; B; _; D )", "D", uint8(4)),
+	Val1("const ( A = iota * 2.5; B )", "B", big.NewRat(5, 2)),
+	Val1("type Color int; const ( Red Color = iota; Green; Blue )", "Blue == 2 && Red < Green", true),
+	Val1("const k = 3", "func() int { const k = 5; return k }()", 5),
+	Val1("const ( A = 1; B = iota; C )", "C", big.NewInt(2)),
+	Val1("const k = 2; x := k*3 + k", "x", 8),
+	Val1("const k = 2.5; x := -k*2 + k", "x", -2.5),
+	CErr("const k = i", "variable i used in constant expression"),
+	CErr("const k = func() int { return 1 }()", "function literal used in constant expression"),
+	CErr("const k = iota; var x = iota", "undefined"),
+	CErr("const ( A, B = 1 )", "missing value"),
+	CErr("const ( A = 1, 2 )", "extra expression"),
+	CErr("const k uint8 = 256", constantOverflows),
+	CErr("const k []int = nil", "invalid constant type"),
+	CErr("const k = 1; const k = 2", "redeclared"),
+	CErr("const k = 1; func g() { k = 2 }", "cannot assign to constant"),
+
 	// Type declarations
 	// Identifiers
 	Run("type T int"),
@@ -266,6 +308,8 @@ var stmtTests = []test{
 	Val1("switch 2 { case 0, 1: i += 2; case 2, 3: i += 4 }", "i", 1+4),
 	Val2("switch func()int{i2++;return 5}() { case 1, 2: i += 2; case 4, 5: i += 4 }", "i", 1+4, "i2", 3),
 	Run("switch i { case i: }"),
+	Val1("for x := 0; x < 3; x++ { switch { case x == 1: break; default: i += 10 }; i++ }", "i", 1+10+1+1+10+1),
+	Val1("L: switch { case true: for { break L } }; i = 2", "i", 2),
 	// TODO(austin) Why doesn't this fail?
 	//CErr("case 1:", "XXX"),
 
@@ -296,6 +340,151 @@ var stmtTests = []test{
 	CErr("fn1 := func() int{ L1: for { for {break L1} } }", "return"),
 	Run("fn1 := func() int{ for true {}; return 1 }"),
 
+	// Range
+	Val1("for x := range ai { i += x }", "i", 1+0+1),
+	Val1("for _, x := range ai { i += x }", "i", 1+1+2),
+	Val2("for k, v := range sli { i += k; i2 += v }", "i", 1+0+1, "i2", 2+1+2),
+	Val2("for i, i2 = range sli {}", "i", 1, "i2", 2),
+	Val1("m := map[int] int{1: 10, 2: 20}; for k, v := range m { i += k * v }", "i", 1+10+40),
+	Val1("m := map[string] int{\"a\": 1, \"b\": 2}; for range m { i++ }", "i", 3),
+	Val2(`for k, r := range "aé" { i++; if r == 'é' { i2 = k } }`, "i", 3, "i2", 1),
+	Val1("var x []int; for range x { i++ }", "i", 1),
+	Val1("var x map[int] int; for range x { i++ }", "i", 1),
+	Val1("for k := range &ai { ai[1] = 5; i += k }", "i", 2),
+	Val1("for _, v := range ai { ai[1] = 5; i += v }", "i", 1+1+2),
+	// Scoping
+	Val2("for i := range sli { i2 = i }", "i", 1, "i2", 1),
+	CErr("for x := range sli {}; x = 1", undefined),
+	// Break/continue
+	Val1("for _, x := range sli { if x == 2 { break }; i += x }", "i", 2),
+	Val1("for _, x := range sli { if x == 1 { continue }; i += x }", "i", 3),
+	Val1("L: for range ai { for range ai { i++; continue L } }", "i", 3),
+	// Errors
+	CErr("for x := range i {}", "cannot range"),
+	CErr("for _, x := range sli { x = s }", opTypes),
+	CErr("for i2 = range s {}; for s = range sli {}", opTypes),
+	// Return checking
+	Run("fn1 := func() int { for range ai {}; return 1 }"),
+	CErr("fn1 := func() int { for range ai { return 1 } }", "return"),
+
+	// Defer
+	Val1("fn1 := func() { defer func() { i += 2 }(); i *= 3 }; fn1()", "i", 1*3+2),
+	Val1("fn1 := func() { defer func() { i *= 3 }(); defer func() { i += 2 }() }; fn1()", "i", (1+2)*3),
+	Val1("fn1 := func() { x := 2; defer func(y int) { i = y }(x); x = 5 }; fn1()", "i", 2),
+	Val1("fn1 := func() (r int) { defer func() { r *= 2 }(); return 3 }; i = fn1()", "i", 6),
+	Val1("fn1 := func() { for x := 0; x < 3; x++ { defer func(y int) { i = i*10 + y }(x) } }; fn1()", "i", 1210),
+	CErr("defer func() {}()", "top level"),
+	CErr("fn1 := func() { defer len(sli) }", "cannot be deferred"),
+	// Panic/recover
+	RErr("panic(\"boom\")", "panic: boom"),
+	RErr("fn1 := func() { panic(42) }; fn1()", "panic: 42"),
+	RErr("fn1 := func() { defer func() { panic(\"second\") }(); panic(\"first\") }; fn1()", "panic: second"),
+	Val1("fn1 := func() (r int) { defer func() { if recover() != nil { r = 7 } }(); panic(\"x\") }; i = fn1()", "i", 7),
+	Val1("fn1 := func() { defer func() { recover() }(); panic(1) }; fn1(); i = 2", "i", 2),
+	Val1("fn2 := func() { panic(1) }; fn1 := func() (r int) { defer func() { if recover() != nil { r = 3 } }(); fn2(); return 1 }; i = fn1()", "i", 3),
+	Val1("b := recover() == nil", "b", true),
+	Val1("fn1 := func() (r bool) { defer func() { r = recover() == nil }(); return }; b := fn1()", "b", true),
+	RErr("fn1 := func() { defer func() { func() { recover() }() }(); panic(2) }; fn1()", "panic: 2"),
+	Run("fn1 := func() int { panic(1) }"),
+	// Run-time errors panic
+	Val1("fn1 := func() (r int) { defer func() { if recover() != nil { r = 9 } }(); x := []int{1}; return x[5] }; i = fn1()", "i", 9),
+	Val1("fn1 := func() (r int) { defer func() { if recover() != nil { r = 9 } }(); return i / (i - 1) }; i = fn1()", "i", 9),
+	Val1("fn1 := func() (r int) { defer func() { if recover() != nil { r = 9 } }(); var p *int; return *p }; i = fn1()", "i", 9),
+	Val1("fn1 := func() (r int) { defer func() { if recover() != nil { r = 9 } }(); var x interface{}; return x.(int) }; i = fn1()", "i", 9),
+	Val1("fn1 := func() { defer func() { s = recover().(error).Error() }(); i = sli[i+5] }; fn1()", "s", "index 6 exceeds length 2"),
+	RErr("fn1 := func() { defer func() { i = 3 }(); i = sli[i+5] }; fn1()", "panic: index 6 exceeds"),
+	CErr("panic()", "not enough"),
+	// Channels
+	Val1("ch := make(chan int, 1); ch <- 5; i = <-ch", "i", 5),
+	Val2("ch := make(chan int, 3); ch <- 1; ch <- 2; i = len(ch); i2 = cap(ch)", "i", 2, "i2", 3),
+	Val1("ch := make(chan int); go func() { ch <- 42 }(); i = <-ch", "i", 42),
+	Val1("ch := make(chan int); go func(x int) { ch <- x }(i + 1); i = <-ch", "i", 2),
+	Val1("ch := make(chan int); done := make(chan bool); go func() { for x := range ch { i += x }; done <- true }(); ch <- 1; ch <- 2; close(ch); <-done", "i", 1+1+2),
+	Val2("ch := make(chan int, 1); ch <- 3; close(ch); x, ok := <-ch; i = x; b := ok; x, ok = <-ch; i2 = x; if !ok && b { i2 = 9 }", "i", 3, "i2", 9),
+	Val1("ch := make(chan int, 2); fn1 := func(r <-chan int) int { return <-r }; ch <- 7; i = fn1(ch)", "i", 7),
+	Val1("var ch chan int; b := ch == nil", "b", true),
+	Val1("var ch chan int; i = len(ch) + cap(ch)", "i", 0),
+	RErr("ch := make(chan int); close(ch); ch <- 1", "closed channel"),
+	RErr("ch := make(chan int); close(ch); close(ch)", "closed channel"),
+	CErr("ch := make(chan int); b := closed(ch)", "use v, ok := <-ch"),
+	RErr("ch := make(chan int, i - 2)", "negative capacity"),
+	CErr("ch := make(<-chan int); ch <- 1", "receive-only"),
+	CErr("ch := make(chan<- int); i = <-ch", "send-only"),
+	CErr("ch := make(<-chan int); close(ch)", "receive-only"),
+	CErr("i = <-i", "non-channel"),
+	CErr("ch := make(chan int); ch <- s", opTypes),
+	CErr("ch := make(chan int); for k, v := range ch {}", "one iteration variable"),
+
+	// Select
+	Val1("ch := make(chan int, 1); ch <- 4; select { case x := <-ch: i = x }", "i", 4),
+	Val1("ch := make(chan int); select { case x := <-ch: i = x; default: i = 7 }", "i", 7),
+	Val1("ch := make(chan int, 1); select { case ch <- 3: i = <-ch; default: i = 7 }", "i", 3),
+	Val1("ch := make(chan int); select { case ch <- 3: i = 1; default: i = 7 }", "i", 7),
+	Val2("ch := make(chan int); close(ch); select { case x, ok := <-ch: i = x; if !ok { i2 = 5 } }", "i", 0, "i2", 5),
+	Val1("ch := make(chan int, 1); ch <- 6; select { case i = <-ch: }", "i", 6),
+	Val1("ch := make(chan int, 1); ch <- 6; select { case <-ch: i = 2 }", "i", 2),
+	Val1("var ch chan int; select { case <-ch: i = 2; default: i = 3 }", "i", 3),
+	Val1("ch := make(chan int); go func() { ch <- 9 }(); select { case x := <-ch: i = x }", "i", 9),
+	Val1("ch := make(chan int, 1); for { select { case ch <- 1: i++; continue; default: }; break }", "i", 2),
+	Val1("ch := make(chan int, 1); ch <- 1; select { case <-ch: i = 2; break; i = 3 }", "i", 2),
+	Val1("a1, a2 := make(chan int, 1), make(chan int, 1); for x := 0; x < 100; x++ { a1 <- 1; a2 <- 2; select { case <-a1: i++; <-a2; case <-a2: i2++; <-a1 } }; ok := i > 1 && i2 > 2", "ok", true),
+	// Scoping
+	CErr("ch := make(chan int); select { case x := <-ch: default: }; i = x", undefined),
+	// Errors
+	CErr("ch := make(chan int); select { default: default: }", "more than one default"),
+	CErr("ch := make(chan int); select { case i = 1: }", "select case"),
+	CErr("select { case <-i: }", "non-channel"),
+	CErr("ch := make(chan<- int); select { case <-ch: }", "send-only"),
+	CErr("ch := make(chan int); select { case x := <-ch: s = x }", opTypes),
+
+	// Type assertions
+	Val1("var x interface{} = 5; i = x.(int)", "i", 5),
+	Val1("var x interface{} = \"a\"; y, ok := x.(int); i = y; if !ok { i2 = 3 }", "i", 0),
+	Val2("var x interface{} = 4; y, ok := x.(int); i = y; if ok { i2 = 3 }", "i", 4, "i2", 3),
+	Val1("var x interface{} = 4; var ok bool; s, ok = x.(string); b := !ok", "b", true),
+	Val1("var x interface{} = 4; y := x.(interface{}); i = y.(int)", "i", 4),
+	Val1("type T int; var y T = 2; var x interface{} = y; _, ok := x.(int); b := !ok", "b", true),
+	RErr("var x interface{} = \"a\"; i = x.(int)", "interface conversion: interface {} is string, not int"),
+	RErr("var x interface{}; i = x.(int)", "interface is nil"),
+	RErr("var x interface{} = 1; y := x.(interface{ M() })", "missing method M"),
+	CErr("i = i.(int)", "not an interface"),
+	CErr("var x interface{} = 1; s = x.(int)", opTypes),
+	CErr("var x interface{} = 1; x.(int) = 2", "cannot assign"),
+	Val1("var x []interface{}; x = append(x, 1, \"a\"); i = x[0].(int) + len(x)", "i", 3),
+	Val1("y := 2; var x []interface{}; x = append(x, y); i = x[0].(int)", "i", 2),
+	Val1("x := map[interface{}]int{}; x[1] = 4; x[\"a\"] = 5; i = x[1] + x[\"a\"]", "i", 9),
+	Val1("x := map[interface{}]int{}; y := 3; x[y] = 4; i = x[y]", "i", 4),
+	Val1("x := map[interface{}]int{1: 4}; _, ok := x[2]; b := !ok", "b", true),
+	Val1("x := map[interface{}]int{1: 4, \"a\": 5}; i = x[1] + x[\"a\"]", "i", 9),
+	Val1("x := map[interface{}]int{2: 4}; for k, v := range x { i = k.(int) + v }", "i", 6),
+	Val1("var x []interface{}; x = append(x, nil); b := x[0] == nil", "b", true),
+	// Type switches
+	Val1("var x interface{} = 3; switch y := x.(type) { case int: i = y; case string: i = 2 }", "i", 3),
+	Val1("var x interface{} = \"ab\"; switch y := x.(type) { case int: i = y; case string: i = len(y) }", "i", 2),
+	Val1("var x interface{}; switch x.(type) { case int: i = 1; case nil: i = 2; default: i = 3 }", "i", 2),
+	Val1("var x interface{} = 1.5; switch x.(type) { case int, string: i = 1; default: i = 3 }", "i", 3),
+	Val1("var x interface{} = 1; switch y := x.(type) { case int, string: var ok bool; i, ok = y.(int); b := ok }", "i", 1),
+	Val1("var x interface{} = true; switch z := 2; y := x.(type) { case bool: if y { i = z } }", "i", 2),
+	Val1("var x interface{} = 1; switch x.(type) { case interface{ M() }: i = 1; case interface{}: i = 2 }", "i", 2),
+	CErr("var x interface{} = 1; switch y := x.(type) { case int: }; i = y", undefined),
+	CErr("var x interface{} = 1; switch y := x.(type) { case int: s = y }", opTypes),
+	Val1("for x := 0; x < 3; x++ { var y interface{} = x; switch y.(type) { case int: if x == 1 { break }; i += 10 }; i++ }", "i", 1+10+1+1+10+1),
+	Val1("var x interface{} = 1; L: switch x.(type) { case int: for { break L } }; i = 2", "i", 2),
+	CErr("switch i.(type) {}", "non-interface"),
+	CErr("var x interface{} = 1; switch x.(type) { default: default: }", "more than one default"),
+
+	// nil
+	Val1("var x *int; b := x == nil", "b", true),
+	Val1("x := &i; b := x != nil", "b", true),
+	Val1("var x []int; b := x == nil", "b", true),
+	Val1("var x interface{}; b := nil == x", "b", true),
+	Val1("var x func(); x = nil; b := x == nil", "b", true),
+	Val1("type T struct { p *int; s []int; m map[int]int; f func() }; x := T{nil, nil, nil, nil}; b := x.p == nil && x.s == nil && x.f == nil", "b", true),
+	Val1("type T struct { p *int; s []int; m map[int]int; f func() int }; x := T{&i, []int{2}, map[int]int{3: 4}, func() int { return 5 }}; i = *x.p + x.s[0] + x.m[3] + x.f()", "i", 12),
+	CErr("b := nil == nil", opTypes),
+	CErr("i = nil", "cannot use nil"),
+	CErr("b := sli == sli", opTypes),
+
 	// Selectors
 	Val1("var x struct { a int; b int }; x.a = 42; i = x.a", "i", 42),
 	Val1("type T struct { x int }; var y struct { T }; y.x = 42; i = y.x", "i", 42),
@@ -339,6 +528,59 @@ var stmtTests = []test{
 	Run("func f1(){}"),
 	Run2("func f1(){}", "f1()"),
 
+	// Methods
+	Val1(methodDecls, "func() int { var p P; p.Set(4); return p.Get() }()", 4),
+	Val1(methodDecls, "func() int { p := new(P); p.Set(5); return p.Get() }()", 5),
+	Val1(methodDecls, "func() int { var p P; p.Bump(); return p.x }()", 0),
+	Val1(methodDecls, "func() int { var p P; f := p.Set; f(3); return p.x }()", 3),
+	Val1(methodDecls, "func() int { var p P; p.x = 1; g := p.Get; p.x = 2; return g() + g() }()", 2),
+	Val1(methodDecls, "func() int { var p P; (*P).Set(&p, 6); return P.Get(p) + (*P).Get(&p) }()", 12),
+	Val1(methodDecls, "func() int { type Q struct { P; y int }; var q Q; q.Set(7); return q.Get() }()", 7),
+	Val1(methodDecls, "func() int { type R struct { *P }; var r R; r.P = new(P); r.Set(8); return r.Get() }()", 8),
+	Val1("type N struct { n int }; func (n N) Fact() int { if n.n <= 1 { return 1 }; return n.n * N{n.n - 1}.Fact() }", "N{5}.Fact()", 120),
+	test([]job{{code: methodDecls, noval: true}, {code: "func() int { var p *P; return p.Get() }()", rterr: "nil pointer"}}),
+	Val1("type C struct { n int }; func (c *C) Catch() { if recover() != nil { c.n = 1 } }", "func() int { c := new(C); func() { defer c.Catch(); panic(1) }(); return c.n }()", 1),
+	CErr("func (i int) M() {}", "non-local type"),
+	CErr("type P struct { x int }; func (p P) x() {}", "both field and method"),
+	CErr("type P int; func (p P) M() {}; func (p *P) M() {}", "redeclared"),
+	CErr("type P *int; func (p P) M() {}", "invalid receiver"),
+	CErr("type P struct { x int }; func (p *P) Set() {}; func h() P { return P{1} }; func g() { h().Set() }", "cannot call pointer method"),
+	CErr("type P struct { x int }; func (p *P) Set() {}; func g() { P.Set(P{}) }", "needs pointer receiver"),
+	CErr("type P struct { x int }; func (p P) Get() {}; func g() { var p P; p.Put() }", "no field or method"),
+
+	// Interfaces
+	Val1(shapeDecls, "func() int { var x Shape = Sq{3}; return x.Area() }()", 9),
+	Val1(shapeDecls, "func() int { var x Shape = &Rect{2, 5}; return x.Area() }()", 10),
+	Val1(shapeDecls, "func() int { var x Shape = &Sq{4}; return x.Area() }()", 16),
+	Val1(shapeDecls, "func() int { n := 0; for _, x := range []Shape{Sq{1}, &Rect{2, 3}, Sq{2}} { n += x.Area() }; return n }()", 11),
+	Val1(shapeDecls, "func() int { r := &Rect{1, 1}; var x Shape = r; r.w = 7; return x.Area() }()", 7),
+	Val1(shapeDecls, "func() int { var x Shape = Sq{3}; f := x.Area; x = Sq{1}; return f() }()", 9),
+	Val1(shapeDecls, "func() int { type T struct { Sq }; var x Shape = T{Sq{5}}; return x.Area() }()", 25),
+	Val1(shapeDecls, "func() int { type Named interface { Shape; Name() string }; var n Named; var x Shape = n; if x == nil { return 1 }; return 0 }()", 1),
+	Val1(shapeDecls, "func() bool { var x Shape = Sq{2}; return x == Sq{2} }()", true),
+	Val1(shapeDecls, "func() int { var e interface{} = Sq{6}; return e.(Shape).Area() }()", 36),
+	Val1("m := map[string]interface{}{\"a\": 1, \"b\": \"x\"}", "m[\"a\"].(int) + len(m[\"b\"].(string))", 2),
+	test([]job{{code: shapeDecls, noval: true}, {code: "func() int { var x Shape; return x.Area() }()", rterr: "nil pointer"}}),
+	CErr(shapeDecls+"; func g() { var x Shape = Rect{1, 2} }", "does not implement.*missing method Area"),
+	CErr(shapeDecls+"; func g() { var x Shape = 1 }", "does not implement"),
+	CErr(shapeDecls+"; func g() { var e interface{}; var x Shape = e }", "does not implement"),
+	CErr(shapeDecls+"; func g() { var x Shape; x.Perimeter() }", "no field or method"),
+
+	// Variadic functions
+	Val1(sumDecl, "sum(1)", 1),
+	Val1(sumDecl, "sum(1, 2, 3)", 6),
+	Val1(sumDecl, "sum(1, []int{2, 3, 4}...)", 10),
+	Val1(sumDecl, "func() int { var s []int; return sum(5, s...) }()", 5),
+	Val1(sumDecl, "func() int { f := func(xs ...int) int { return len(xs) }; return f(1, 2) + f() }()", 2),
+	Val1("func count(xs ...interface{}) int { return len(xs) }", "count(1, \"a\", nil)", 3),
+	Val1("func pair() (int, int) { return 2, 3 }; func add(xs ...int) int { return xs[0] + xs[1] }", "add(pair())", 5),
+	Val1("x := []int{1}", "len(append(x, []int{2, 3}...))", 3),
+	Val1("x := []byte{1}", "len(append(x, \"ab\"...))", 3),
+	CErr(sumDecl+"; func g() { sum(1, \"a\") }", "argument"),
+	CErr(sumDecl+"; func g() { sum(1, 2, []int{3}...) }", "argument"),
+	CErr("func h(x int) {}; func g() { h([]int{1}...) }", "non-variadic"),
+	CErr("func g() { len([]int{}...) }", "invalid use of ..."),
+
 	// Imports
 	CErr(`import "__a"`, "could not find files.*__a"),
 }
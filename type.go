@@ -9,9 +9,11 @@ import (
 	"go/ast"
 	"go/token"
 	"log"
+	"math"
 	"math/big"
 	"reflect"
 	"sort"
+	"sync"
 	"unsafe" // For Sizeof
 )
 
@@ -72,6 +74,10 @@ var universePos = token.NoPos
  * Type array maps.  These are used to memoize composite types.
  */
 
+// typesMu guards the maps memoizing composite types, since Worlds
+// may compile and convert types concurrently.
+var typesMu sync.Mutex
+
 type typeArrayMapEntry struct {
 	key  []Type
 	v    interface{}
@@ -143,7 +149,9 @@ func (commonType) isIdeal() bool { return false }
 
 func (commonType) Pos() token.Pos { return token.NoPos }
 
-func (t commonType) create(v Thing, thread *Thread) Value { panic(fmt.Sprint("Create for ", t, " is undefined")) }
+func (t commonType) create(v Thing, thread *Thread) Value {
+	panic(&ConvertError{fmt.Sprintf("cannot convert native value of type %T", v)})
+}
 
 /*
  * Package
@@ -152,6 +160,9 @@ func (t commonType) create(v Thing, thread *Thread) Value { panic(fmt.Sprint("Cr
 type packageField struct {
 	Name string
 	Type Type
+	// The value of an untyped constant, which is not stored in
+	// the package value.
+	Const Value
 }
 
 type packageType struct {
@@ -159,13 +170,17 @@ type packageType struct {
 	Elems []packageField
 }
 
-var packageTypes = make(map[string]*packageType)
+// packageTypes is keyed by the package's scope rather than its path,
+// since registering a native package again gives the path a new scope.
+var packageTypes = make(map[*Scope]*packageType)
 
-func newPackageType(path string, fields []packageField) *packageType {
-	t, ok := packageTypes[path]
+func newPackageType(scope *Scope, fields []packageField) *packageType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
+	t, ok := packageTypes[scope]
 	if !ok {
 		t = &packageType{commonType{}, fields}
-		packageTypes[path] = t
+		packageTypes[scope] = t
 	}
 	return t
 }
@@ -186,6 +201,57 @@ func (p *packageType) Zero() Value {
 	return &pkg
 }
 
+/*
+ * Sizes
+ */
+
+// sizeOf estimates the number of bytes a value of type t occupies,
+// as it would in compiled Go.  Interpreter allocations are accounted
+// by this estimate.
+func sizeOf(t Type) int64 {
+	switch t := t.lit().(type) {
+	case *boolType:
+		return 1
+	case *uintType:
+		if t.Bits == 0 {
+			return 8
+		}
+		return int64(t.Bits / 8)
+	case *intType:
+		if t.Bits == 0 {
+			return 8
+		}
+		return int64(t.Bits / 8)
+	case *floatType:
+		if t.Bits == 0 {
+			return 8
+		}
+		return int64(t.Bits / 8)
+	case *stringType, *InterfaceType:
+		return 16
+	case *SliceType:
+		return 24
+	case *ArrayType:
+		size := sizeOf(t.Elem)
+		if size > 0 && t.Len > math.MaxInt64/size {
+			return math.MaxInt64
+		}
+		return t.Len * size
+	case *StructType:
+		var n int64
+		for _, f := range t.Elems {
+			size := sizeOf(f.Type)
+			if n > math.MaxInt64-size {
+				return math.MaxInt64
+			}
+			n += size
+		}
+		return n
+	}
+	// Pointers, functions, maps and channels are one word.
+	return 8
+}
+
 /*
  * Bool
  */
@@ -198,7 +264,7 @@ var BoolType = universe.DefineType("bool", universePos, &boolType{})
 
 func (t *boolType) create(v Thing, thread *Thread) Value {
 	z := t.Zero()
-	*(z.(*boolV)) = boolV(v.(bool))
+	*(z.(*boolV)) = boolV(reflect.ValueOf(v).Bool())
 	return z
 }
 
@@ -259,25 +325,9 @@ func (t *uintType) isInteger() bool { return true }
 func (t *uintType) String() string { return "<" + t.name + ">" }
 
 func (t *uintType) create(v Thing, thread *Thread) Value {
+	// Going through reflect also accepts named native types.
 	z := t.Zero()
-	switch t.Bits {
-	case 0:
-		if t.Ptr {
-			*(z.(*uintptrV)) = uintptrV(v.(uintptr))
-		} else {
-			*(z.(*uintV)) = uintV(v.(uint))
-		}
-	case 8:
-		*(z.(*uint8V)) = uint8V(v.(uint8))
-	case 16:
-		*(z.(*uint16V)) = uint16V(v.(uint16))
-	case 32:
-		*(z.(*uint32V)) = uint32V(v.(uint32))
-	case 64:
-		*(z.(*uint64V)) = uint64V(v.(uint64))
-	default:
-		panic("unexpected uint bit count")
-	}
+	z.(UintValue).Set(thread, reflect.ValueOf(v).Uint())
 	return z
 }
 
@@ -363,20 +413,7 @@ func (t *intType) String() string { return "<" + t.name + ">" }
 
 func (t *intType) create(v Thing, thread *Thread) Value {
 	z := t.Zero()
-	switch t.Bits {
-	case 0:
-		*(z.(*intV)) = intV(v.(int))
-	case 8:
-		*(z.(*int8V)) = int8V(v.(int8))
-	case 16:
-		*(z.(*int16V)) = int16V(v.(int16))
-	case 32:
-		*(z.(*int32V)) = int32V(v.(int32))
-	case 64:
-		*(z.(*int64V)) = int64V(v.(int64))
-	default:
-		panic("unexpected int bit count")
-	}
+	z.(IntValue).Set(thread, reflect.ValueOf(v).Int())
 	return z
 }
 
@@ -479,14 +516,7 @@ func (t *floatType) String() string { return "<" + t.name + ">" }
 
 func (t *floatType) create(v Thing, thread *Thread) Value {
 	z := t.Zero()
-	switch t.Bits {
-	case 32:
-		*(z.(*float32V)) = float32V(v.(float32))
-	case 64:
-		*(z.(*float64V)) = float64V(v.(float64))
-	default:
-		panic("unexpected float bit count")
-	}
+	z.(FloatValue).Set(thread, reflect.ValueOf(v).Float())
 	return z
 }
 
@@ -577,7 +607,7 @@ func (t *stringType) String() string { return "<string>" }
 
 func (t *stringType) create(v Thing, thread *Thread) Value {
 	z := t.Zero()
-	*(z.(*stringV)) = stringV(v.(string))
+	*(z.(*stringV)) = stringV(reflect.ValueOf(v).String())
 	return z
 }
 
@@ -602,6 +632,8 @@ var arrayTypes = make(map[int64]map[Type]*ArrayType)
 // and the same array length.
 
 func NewArrayType(len int64, elem Type) *ArrayType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
 	ts, ok := arrayTypes[len]
 	if !ok {
 		ts = make(map[Type]*ArrayType)
@@ -627,6 +659,14 @@ func (t *ArrayType) lit() Type { return t }
 
 func (t *ArrayType) String() string { return "[]" + t.Elem.String() }
 
+// create returns a copy of the native array v.  The copy is kept in
+// native memory, so that GetNative returns it with its native type.
+func (t *ArrayType) create(v Thing, thread *Thread) Value {
+	rv := reflect.New(reflect.TypeOf(v)).Elem()
+	rv.Set(reflect.ValueOf(v))
+	return refArrayV{rv, t.Elem}
+}
+
 func (t *ArrayType) Zero() Value {
 	res := arrayV(make([]Value, t.Len))
 	// TODO(austin) It's unfortunate that each element is
@@ -664,6 +704,8 @@ var structTypes = newTypeArrayMap()
 // same name.
 
 func NewStructType(fields []StructField) *StructType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
 	// Start by looking up just the types
 	fts := make([]Type, len(fields))
 	for i, f := range fields {
@@ -731,12 +773,11 @@ func (t *StructType) lit() Type { return t }
 
 func (t *StructType) create(v Thing, thread *Thread) Value {
 	z := *(t.Zero().(*structV))
-	typ := reflect.TypeOf(v)
 	val := reflect.ValueOf(v)
-
-	for i := 0; i < typ.NumField(); i++ {
-		z.content[i] = ValueFromNative(val.Field(i).Interface(), thread)
+	for i, fi := range nativeFieldIndex(val.Type()) {
+		z.content[i] = valueFromReflect(thread, val.Field(fi), t.Elems[i].Type)
 	}
+	z.native = val
 	return &z
 }
 
@@ -755,7 +796,7 @@ func (t *StructType) String() string {
 }
 
 func (t *StructType) Zero() Value {
-	res := structV{make([]Value, len(t.Elems)), t}
+	res := structV{make([]Value, len(t.Elems)), t, reflect.Value{}}
 	for i, f := range t.Elems {
 		res.content[i] = f.Type.Zero()
 	}
@@ -776,6 +817,8 @@ var ptrTypes = make(map[Type]*PtrType)
 // Two pointer types are identical if they have identical base types.
 
 func NewPtrType(elem Type) *PtrType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
 	t, ok := ptrTypes[elem]
 	if !ok {
 		t = &PtrType{commonType{}, elem}
@@ -796,13 +839,18 @@ func (t *PtrType) lit() Type { return t }
 
 func (t *PtrType) String() string { return "*" + t.Elem.String() }
 
+// create returns a pointer that aliases the native memory v points
+// to, so that changes made by scripts are seen by the host and vice
+// versa.
 func (t *PtrType) create(v Thing, thread *Thread) Value {
-	z := t.Zero()
-	z.(*ptrV).target = ValueFromNative(reflect.ValueOf(v).Elem().Interface(), thread)
-	return z
+	rv := reflect.ValueOf(v)
+	if rv.IsNil() {
+		return t.Zero()
+	}
+	return &ptrV{refFromNative(rv.Elem(), t.Elem), t}
 }
 
-func (t *PtrType) Zero() Value { return &ptrV{nil} }
+func (t *PtrType) Zero() Value { return &ptrV{nil, t} }
 
 /*
  * Function
@@ -811,7 +859,9 @@ func (t *PtrType) Zero() Value { return &ptrV{nil} }
 type FuncType struct {
 	commonType
 	// TODO(austin) Separate receiver Type for methods?
-	In       []Type
+	In []Type
+	// If Variadic is true, the final element of In is the slice
+	// type []T of the ...T parameter.
 	Variadic bool
 	Out      []Type
 	builtin  string
@@ -832,6 +882,7 @@ var (
 	panicType   = &FuncType{builtin: "panic"}
 	printType   = &FuncType{builtin: "print"}
 	printlnType = &FuncType{builtin: "println"}
+	recoverType = &FuncType{builtin: "recover"}
 	copyType    = &FuncType{builtin: "copy"}
 )
 
@@ -841,6 +892,8 @@ var (
 // type. Parameter and result names are not required to match.
 
 func NewFuncType(in []Type, variadic bool, out []Type) *FuncType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
 	inMap := funcTypes
 	if variadic {
 		inMap = variadicFuncTypes
@@ -904,31 +957,45 @@ func typeListString(ts []Type, ns []*ast.Ident) string {
 	return s
 }
 
+// paramListString is like typeListString for the parameters of ft,
+// except that the final parameter of a variadic function is written
+// as ...T instead of []T.
+func paramListString(ft *FuncType, ns []*ast.Ident) string {
+	if !ft.Variadic {
+		return typeListString(ft.In, ns)
+	}
+	n := len(ft.In) - 1
+	var s string
+	if ns != nil {
+		s = typeListString(ft.In[:n], ns[:n])
+	} else {
+		s = typeListString(ft.In[:n], nil)
+	}
+	if n > 0 {
+		s += ", "
+	}
+	if ns != nil && ns[n] != nil {
+		s += ns[n].Name + " "
+	}
+	return s + "..." + ft.In[n].lit().(*SliceType).Elem.String()
+}
+
 func (t *FuncType) String() string {
 	if t.builtin != "" {
 		return "built-in function " + t.builtin
 	}
-	args := typeListString(t.In, nil)
-	if t.Variadic {
-		if len(args) > 0 {
-			args += ", "
-		}
-		args += "..."
-	}
-	s := "func(" + args + ")"
+	s := "func(" + paramListString(t, nil) + ")"
 	if len(t.Out) > 0 {
 		s += " (" + typeListString(t.Out, nil) + ")"
 	}
 	return s
 }
 
-func (t *FuncType) Zero() Value { return &funcV{nil} }
+func (t *FuncType) Zero() Value { return &funcV{nil, t} }
 
 type FuncDecl struct {
-	Type *FuncType
-	Name *ast.Ident // nil for function literals
-	// InNames will be one longer than Type.In if this function is
-	// variadic.
+	Type     *FuncType
+	Name     *ast.Ident // nil for function literals
 	InNames  []*ast.Ident
 	OutNames []*ast.Ident
 }
@@ -943,21 +1010,40 @@ func (t *FuncDecl) String() string {
 }
 
 func funcTypeString(ft *FuncType, ins []*ast.Ident, outs []*ast.Ident) string {
-	s := "("
-	s += typeListString(ft.In, ins)
-	if ft.Variadic {
-		if len(ft.In) > 0 {
-			s += ", "
-		}
-		s += "..."
-	}
-	s += ")"
+	s := "(" + paramListString(ft, ins) + ")"
 	if len(ft.Out) > 0 {
 		s += " (" + typeListString(ft.Out, outs) + ")"
 	}
 	return s
 }
 
+/*
+ * Nil
+ */
+
+// nilType is the type of the predeclared identifier nil.  Like an
+// ideal type, it is never the type of a variable; nil is converted to
+// the type it is assigned to or compared with.
+type nilType struct {
+	commonType
+}
+
+var NilType Type = &nilType{}
+
+func (t *nilType) compat(o Type, conv bool) bool {
+	_, ok := o.lit().(*nilType)
+	return ok
+}
+
+func (t *nilType) lit() Type { return t }
+
+func (t *nilType) String() string { return "nil" }
+
+func (t *nilType) Zero() Value {
+	log.Panic("nil has no zero value")
+	panic("unreachable")
+}
+
 /*
  * Interface
  */
@@ -982,6 +1068,8 @@ type IMethod struct {
 var interfaceTypes = newTypeArrayMap()
 
 func NewInterfaceType(methods []IMethod, embeds []*InterfaceType) *InterfaceType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
 	// Count methods of embedded interfaces
 	nMethods := len(methods)
 	for _, e := range embeds {
@@ -1003,7 +1091,7 @@ func NewInterfaceType(methods []IMethod, embeds []*InterfaceType) *InterfaceType
 	sort.Sort(iMethodSorter(allMethods))
 
 	mts := make([]Type, len(allMethods))
-	for i, m := range methods {
+	for i, m := range allMethods {
 		mts[i] = m.Type
 	}
 	tMapI := interfaceTypes.Get(mts)
@@ -1025,6 +1113,9 @@ func NewInterfaceType(methods []IMethod, embeds []*InterfaceType) *InterfaceType
 	return t
 }
 
+// EmptyInterfaceType is the type interface{}.
+var EmptyInterfaceType = NewInterfaceType(nil, nil)
+
 type iMethodSorter []IMethod
 
 func (s iMethodSorter) Less(a, b int) bool { return s[a].Name < s[b].Name }
@@ -1084,15 +1175,6 @@ func (t *InterfaceType) implementedBy(o Type) (*IMethod, bool) {
 	// requires identical types.
 
 	switch o := o.(type) {
-	case *NamedType:
-		for _, tm := range t.methods {
-			sm, ok := o.methods[tm.Name]
-			if !ok || sm.decl.Type != tm.Type {
-				return &tm, false
-			}
-		}
-		return nil, true
-
 	case *InterfaceType:
 		var ti, oi int
 		for ti < len(t.methods) && oi < len(o.methods) {
@@ -1116,11 +1198,32 @@ func (t *InterfaceType) implementedBy(o Type) (*IMethod, bool) {
 		return nil, true
 	}
 
-	return &t.methods[0], false
+	if o, ok := o.(*opaqueType); ok {
+		for i := range t.methods {
+			tm := &t.methods[i]
+			if ft, ok := o.methodType(tm.Name); !ok || ft != tm.Type {
+				return tm, false
+			}
+		}
+		return nil, true
+	}
+
+	for i := range t.methods {
+		tm := &t.methods[i]
+		sm, _ := findMethod(o, tm.Name)
+		if sm == nil || sm.decl.Type != tm.Type {
+			return tm, false
+		}
+	}
+	return nil, true
 }
 
 func (t *InterfaceType) Zero() Value { return &interfaceV{} }
 
+func (t *InterfaceType) create(v Thing, thread *Thread) Value {
+	return &interfaceV{interfaceFromNative(thread, v)}
+}
+
 /*
  * Slice
  */
@@ -1135,6 +1238,8 @@ var sliceTypes = make(map[Type]*SliceType)
 // Two slice types are identical if they have identical element types.
 
 func NewSliceType(elem Type) *SliceType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
 	t, ok := sliceTypes[elem]
 	if !ok {
 		t = &SliceType{commonType{}, elem}
@@ -1155,10 +1260,21 @@ func (t *SliceType) lit() Type { return t }
 
 func (t *SliceType) String() string { return "[]" + t.Elem.String() }
 
+// create returns a slice sharing the backing array of the native
+// slice v.
+func (t *SliceType) create(v Thing, thread *Thread) Value {
+	rv := reflect.ValueOf(v)
+	if rv.IsNil() {
+		return t.Zero()
+	}
+	base := refArrayV{rv.Slice(0, rv.Cap()), t.Elem}
+	return &sliceV{Slice{base, int64(rv.Len()), int64(rv.Cap())}, t}
+}
+
 func (t *SliceType) Zero() Value {
 	// The value of an uninitialized slice is nil. The length and
 	// capacity of a nil slice are 0.
-	return &sliceV{Slice{nil, 0, 0}}
+	return &sliceV{Slice{nil, 0, 0}, t}
 }
 
 /*
@@ -1174,6 +1290,8 @@ type MapType struct {
 var mapTypes = make(map[Type]map[Type]*MapType)
 
 func NewMapType(key Type, elem Type) *MapType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
 	ts, ok := mapTypes[key]
 	if !ok {
 		ts = make(map[Type]*MapType)
@@ -1201,22 +1319,166 @@ func (t *MapType) String() string { return "map[" + t.Key.String() + "] " + t.El
 
 func (t *MapType) Zero() Value {
 	// The value of an uninitialized map is nil.
-	return &mapV{nil}
+	return &mapV{nil, t}
+}
+
+func (t *MapType) create(v Thing, thread *Thread) Value {
+	rv := reflect.ValueOf(v)
+	if rv.IsNil() {
+		return t.Zero()
+	}
+	m := make(evalMap, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		k := valueFromReflect(thread, iter.Key(), t.Key)
+		m[valueMapKey(thread, k)] = valueFromReflect(thread, iter.Value(), t.Elem)
+	}
+	return &mapV{m, t}
 }
 
 /*
+ * Channel type
+ */
+
 type ChanType struct {
-	// TODO(austin)
+	commonType
+	// The direction of the channel; ast.SEND, ast.RECV, or both.
+	Dir  ast.ChanDir
+	Elem Type
+}
+
+var chanTypes = make(map[ast.ChanDir]map[Type]*ChanType)
+
+func NewChanType(dir ast.ChanDir, elem Type) *ChanType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
+	ts, ok := chanTypes[dir]
+	if !ok {
+		ts = make(map[Type]*ChanType)
+		chanTypes[dir] = ts
+	}
+	t, ok := ts[elem]
+	if !ok {
+		t = &ChanType{commonType{}, dir, elem}
+		ts[elem] = t
+	}
+	return t
+}
+
+func (t *ChanType) compat(o Type, conv bool) bool {
+	t2, ok := o.lit().(*ChanType)
+	if !ok {
+		return false
+	}
+	return t.Dir == t2.Dir && t.Elem.compat(t2.Elem, conv)
+}
+
+func (t *ChanType) lit() Type { return t }
+
+func (t *ChanType) String() string {
+	switch t.Dir {
+	case ast.SEND:
+		return "chan<- " + t.Elem.String()
+	case ast.RECV:
+		return "<-chan " + t.Elem.String()
+	}
+	return "chan " + t.Elem.String()
+}
+
+func (t *ChanType) Zero() Value {
+	// The value of an uninitialized channel is nil.
+	return &chanV{nil}
 }
-*/
 
 /*
  * Named types
  */
 
 type Method struct {
+	// The declaration of the method, without its receiver.
 	decl *FuncDecl
-	fn   Func
+	// The receiver type, either the named type or a pointer to
+	// it.
+	recv Type
+	// The function implementing the method, which takes the
+	// receiver as its first argument.
+	fn Func
+}
+
+// ptrRecv returns true if m has a pointer receiver.
+func (m *Method) ptrRecv() bool {
+	_, ok := m.recv.(*PtrType)
+	return ok
+}
+
+// findMethod looks up the method called name in the method set of t,
+// including methods promoted from embedded fields.  It returns the
+// method and a function that extracts the method's receiver from a
+// value of type t, or nil if t has no such method or the name is
+// ambiguous.  Pointer methods are only in the method set if t is a
+// pointer or they are reached through an embedded pointer.
+func findMethod(t Type, name string) (*Method, func(*Thread, Value) Value) {
+	type candidate struct {
+		t    Type
+		addr bool
+		get  func(*Thread, Value) Value
+	}
+	level := []candidate{{t, false, func(_ *Thread, v Value) Value { return v }}}
+	visited := make(map[Type]bool)
+	for len(level) > 0 {
+		var found *Method
+		var recv func(*Thread, Value) Value
+		var next []candidate
+		for _, c := range level {
+			if visited[c.t] {
+				continue
+			}
+			visited[c.t] = true
+
+			ct, addr, get := c.t, c.addr, c.get
+			if pt, ok := ct.(*PtrType); ok {
+				ct, addr = pt.Elem, true
+				pget := get
+				get = func(t *Thread, v Value) Value {
+					x := pget(t, v).(PtrValue).Get(t)
+					if x == nil {
+						t.Panic(NilPointerError{})
+					}
+					return x
+				}
+			}
+
+			if nt, ok := ct.(*NamedType); ok {
+				if m, ok := nt.methods[name]; ok && (addr || !m.ptrRecv()) {
+					if found != nil {
+						return nil, nil
+					}
+					found, recv = m, get
+					if m.ptrRecv() {
+						recv = func(t *Thread, v Value) Value { return &ptrV{get(t, v), nil} }
+					}
+					continue
+				}
+			}
+
+			if st, ok := ct.lit().(*StructType); ok {
+				for i, f := range st.Elems {
+					if !f.Anonymous {
+						continue
+					}
+					index, sget := i, get
+					next = append(next, candidate{f.Type, addr, func(t *Thread, v Value) Value {
+						return sget(t, v).(StructValue).Field(t, index)
+					}})
+				}
+			}
+		}
+		if found != nil {
+			return found, recv
+		}
+		level = next
+	}
+	return nil, nil
 }
 
 type NamedType struct {
@@ -1228,13 +1490,13 @@ type NamedType struct {
 	Def Type
 	// True while this type is being defined.
 	incomplete bool
-	methods    map[string]Method
+	methods    map[string]*Method
 }
 
 // TODO(austin) This is temporarily needed by the debugger's remote
 // type parser.  This should only be possible with block.DefineType.
 func NewNamedType(name string) *NamedType {
-	return &NamedType{token.NoPos, name, nil, true, make(map[string]Method)}
+	return &NamedType{token.NoPos, name, nil, true, make(map[string]*Method)}
 }
 
 func (t *NamedType) Pos() token.Pos {
@@ -1305,6 +1567,8 @@ type MultiType struct {
 var multiTypes = newTypeArrayMap()
 
 func NewMultiType(elems []Type) *MultiType {
+	typesMu.Lock()
+	defer typesMu.Unlock()
 	if t := multiTypes.Get(elems); t != nil {
 		return t.(*MultiType)
 	}
@@ -1364,11 +1628,13 @@ func init() {
 	minFloat64Val = new(big.Rat).Neg(maxFloat64Val)
 
 	// To avoid portability issues all numeric types are distinct
-	// except byte, which is an alias for uint8.
+	// except byte and rune, which are aliases for uint8 and int32.
 
-	// Make byte an alias for the named type uint8.  Type aliases
-	// are otherwise impossible in Go, so just hack it here.
+	// Make byte and rune aliases for the named types uint8 and
+	// int32.  Type aliases are otherwise impossible in Go, so just
+	// hack it here.
 	universe.defs["byte"] = universe.defs["uint8"]
+	universe.defs["rune"] = universe.defs["int32"]
 
 	// Built-in functions
 	universe.DefineConst("append", universePos, appendType, nil)
@@ -1379,7 +1645,9 @@ func init() {
 	universe.DefineConst("len", universePos, lenType, nil)
 	universe.DefineConst("make", universePos, makeType, nil)
 	universe.DefineConst("new", universePos, newType, nil)
+	universe.DefineConst("nil", universePos, NilType, nil)
 	universe.DefineConst("panic", universePos, panicType, nil)
 	universe.DefineConst("print", universePos, printType, nil)
 	universe.DefineConst("println", universePos, printlnType, nil)
+	universe.DefineConst("recover", universePos, recoverType, nil)
 }
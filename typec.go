@@ -7,7 +7,6 @@ package chicklet
 import (
 	"go/ast"
 	"go/token"
-	"log"
 )
 
 /*
@@ -50,7 +49,7 @@ func (a *typeCompiler) compileIdent(x *ast.Ident, allowRec bool) Type {
 	case Type:
 		return def
 	}
-	log.Panicf("name %s has unknown type %T", x.Name, def)
+	a.diagAt(x.Pos(), "%s is not a type", x.Name)
 	return nil
 }
 
@@ -208,18 +207,35 @@ func (a *typeCompiler) compilePtrType(x *ast.StarExpr) Type {
 }
 
 func (a *typeCompiler) compileFuncType(x *ast.FuncType, allowRec bool) *FuncDecl {
-	// TODO(austin) Variadic function types
+	// The final parameter of a variadic function has type ...T,
+	// which is a []T inside the function.
+	params, variadic := x.Params, false
+	if params != nil && len(params.List) > 0 {
+		n := len(params.List) - 1
+		last := params.List[n]
+		if e, ok := last.Type.(*ast.Ellipsis); ok {
+			if len(last.Names) > 1 || e.Elt == nil {
+				a.diagAt(e.Pos(), "can only use ... with final parameter in list")
+				return nil
+			}
+			variadic = true
+			list := make([]*ast.Field, n+1)
+			copy(list, params.List[:n])
+			list[n] = &ast.Field{Names: last.Names, Type: &ast.ArrayType{Lbrack: e.Pos(), Elt: e.Elt}}
+			params = &ast.FieldList{Opening: params.Opening, List: list, Closing: params.Closing}
+		}
+	}
 
 	// The types of parameters and results must be complete.
 	//
 	// TODO(austin) It's not clear they actually have to be complete.
-	in, inNames, _, inBad := a.compileFields(x.Params, allowRec)
+	in, inNames, _, inBad := a.compileFields(params, allowRec)
 	out, outNames, _, outBad := a.compileFields(x.Results, allowRec)
 
 	if inBad || outBad {
 		return nil
 	}
-	return &FuncDecl{NewFuncType(in, false, out), nil, inNames, outNames}
+	return &FuncDecl{NewFuncType(in, variadic, out), nil, inNames, outNames}
 }
 
 func (a *typeCompiler) compileInterfaceType(x *ast.InterfaceType, allowRec bool) *InterfaceType {
@@ -299,6 +315,14 @@ func (a *typeCompiler) compileMapType(x *ast.MapType) Type {
 	return NewMapType(key, val)
 }
 
+func (a *typeCompiler) compileChanType(x *ast.ChanType) Type {
+	elem := a.compileType(x.Value, true)
+	if elem == nil {
+		return nil
+	}
+	return NewChanType(x.Dir, elem)
+}
+
 func (a *typeCompiler) compileType(x ast.Expr, allowRec bool) Type {
 	switch x := x.(type) {
 	case *ast.BadExpr:
@@ -332,21 +356,27 @@ func (a *typeCompiler) compileType(x ast.Expr, allowRec bool) Type {
 		return a.compileMapType(x)
 
 	case *ast.ChanType:
-		goto notimpl
+		return a.compileChanType(x)
 
 	case *ast.ParenExpr:
 		return a.compileType(x.X, allowRec)
 
+	case *ast.SelectorExpr:
+		t, ok := a.block.LookupType(x)
+		if !ok {
+			break
+		}
+		if t == nil {
+			a.diagAt(x.Pos(), "%s.%s is not a type", x.X, x.Sel.Name)
+		}
+		return t
+
 	case *ast.Ellipsis:
 		a.diagAt(x.Pos(), "illegal use of ellipsis")
 		return nil
 	}
 	a.diagAt(x.Pos(), "expression used as type")
 	return nil
-
-notimpl:
-	a.diagAt(x.Pos(), "compileType: %T not implemented", x)
-	return nil
 }
 
 /*
@@ -138,6 +138,25 @@ type MapValue interface {
 	Set(*Thread, Map)
 }
 
+type Chan interface {
+	// Send a value on the channel, blocking until it can be
+	// delivered or buffered.
+	Send(t *Thread, v Value)
+	// Receive a value from the channel, blocking until one is
+	// available.  ok is false if the channel is closed and
+	// empty, in which case v is the zero value.
+	Recv(t *Thread) (v Value, ok bool)
+	Close(t *Thread)
+	Len(*Thread) int64
+	Cap(*Thread) int64
+}
+
+type ChanValue interface {
+	Value
+	Get(*Thread) Chan
+	Set(*Thread, Chan)
+}
+
 type PackageValue interface {
 	Value
 	Get(*Thread) PackageValue
@@ -172,7 +191,7 @@ func (v *uint8V) Assign(t *Thread, o Value) { *v = uint8V(o.(UintValue).Get(t))
 
 func (v *uint8V) Get(*Thread) uint64 { return uint64(*v) }
 
-func (v *uint8V) GetNative(t *Thread) Thing { return uint(v.Get(t)) }
+func (v *uint8V) GetNative(t *Thread) Thing { return uint8(v.Get(t)) }
 
 func (v *uint8V) Set(t *Thread, x uint64) { *v = uint8V(x) }
 
@@ -407,7 +426,35 @@ func (v *arrayV) Assign(t *Thread, o Value) {
 
 func (v *arrayV) Get(*Thread) ArrayValue { return v }
 
-func (v *arrayV) GetNative(t *Thread) Thing { return v.Get(t) }
+// GetNative returns a native array of the elements.  An arrayV does
+// not know its type, so the element type is that of the elements'
+// native values, or interface{} if they differ.  Callers that know
+// the type use nativeValue instead.
+func (v *arrayV) GetNative(t *Thread) Thing {
+	xs := make([]reflect.Value, len(*v))
+	var et reflect.Type
+	for i, e := range *v {
+		x := e.GetNative(t)
+		if x == nil {
+			xs[i] = reflect.Zero(emptyInterfaceNative)
+		} else {
+			xs[i] = reflect.ValueOf(x)
+		}
+		if i == 0 {
+			et = xs[i].Type()
+		} else if et != xs[i].Type() {
+			et = emptyInterfaceNative
+		}
+	}
+	if et == nil {
+		et = emptyInterfaceNative
+	}
+	rv := reflect.New(reflect.ArrayOf(len(xs), et)).Elem()
+	for i, x := range xs {
+		rv.Index(i).Set(x)
+	}
+	return rv.Interface()
+}
 
 func (v *arrayV) Elem(t *Thread, i int64) Value {
 	return (*v)[i]
@@ -425,6 +472,9 @@ func (v *arrayV) Sub(i int64, len int64) ArrayValue {
 type structV struct {
 	content []Value
 	typ Type
+	// The native value this struct was converted from, if any.
+	// Its hidden fields are restored by GetNative.
+	native reflect.Value
 }
 
 // TODO(austin) Should these methods (and arrayV's) be on structV
@@ -441,6 +491,12 @@ func (v *structV) String() string {
 }
 
 func (v *structV) Assign(t *Thread, o Value) {
+	switch o := o.(type) {
+	case *structV:
+		v.native = o.native
+	case refStructV:
+		v.native = reflect.ValueOf(o.rv.Interface())
+	}
 	oa := o.(StructValue)
 	l := len(v.content)
 	for i := 0; i < l; i++ {
@@ -451,13 +507,20 @@ func (v *structV) Assign(t *Thread, o Value) {
 func (v *structV) Get(*Thread) StructValue { return v }
 
 func (v *structV) GetNative(t *Thread) Thing { 
-	nativeType := v.typ.(*StructType).nativeType
+	st := v.typ.(*StructType)
+	bridgeMu.Lock()
+	nativeType := st.nativeType
+	bridgeMu.Unlock()
 	if nativeType == nil {
-		return v.Get(t)
+		return nativeScriptStruct(t, v, st)
 	}
 	rval := reflect.New(nativeType).Elem()
-	for index, val := range(v.content) {
-		rval.Field(index).Set(reflect.ValueOf(val.GetNative(t)))
+	if v.native.IsValid() && v.native.Type() == nativeType {
+		rval.Set(v.native)
+	}
+	for index, fi := range nativeFieldIndex(nativeType) {
+		f := rval.Field(fi)
+		f.Set(nativeValue(t, v.content[index], f.Type()))
 	}
 	return rval.Interface()
 }
@@ -473,6 +536,9 @@ func (v *structV) Field(t *Thread, i int) Value {
 type ptrV struct {
 	// nil if the pointer is nil
 	target Value
+	// The type of the pointer, used to convert it to a native
+	// pointer.  nil if unknown.
+	typ *PtrType
 }
 
 func (v *ptrV) String() string {
@@ -486,9 +552,33 @@ func (v *ptrV) Assign(t *Thread, o Value) { v.target = o.(PtrValue).Get(t) }
 
 func (v *ptrV) Get(*Thread) Value { return v.target }
 
-func (v *ptrV) GetNative(t *Thread) Thing { 
-	nativeTarget := v.target.GetNative(t)
-	return &nativeTarget
+func (v *ptrV) GetNative(t *Thread) Thing {
+	if v.target == nil {
+		if v.typ == nil {
+			return nil
+		}
+		return reflect.Zero(nativeTypeOf(v.typ)).Interface()
+	}
+	// Pointers into native memory return the original pointer.
+	if r, ok := v.target.(refValue); ok {
+		return r.ref().Addr().Interface()
+	}
+	// Otherwise the target is copied, and a native call writes the
+	// copy back when it returns.
+	if v.typ != nil {
+		p := reflect.New(nativeTypeOf(v.typ.Elem))
+		p.Elem().Set(nativeValue(t, v.target, p.Type().Elem()))
+		t.copied(v.target, p, v)
+		return p.Interface()
+	}
+	x := v.target.GetNative(t)
+	if x == nil {
+		return nil
+	}
+	p := reflect.New(reflect.TypeOf(x))
+	p.Elem().Set(reflect.ValueOf(x))
+	t.copied(v.target, p, v)
+	return p.Interface()
 }
 
 func (v *ptrV) Set(t *Thread, x Value) { v.target = x }
@@ -499,6 +589,7 @@ func (v *ptrV) Set(t *Thread, x Value) { v.target = x }
 
 type funcV struct {
 	target Func
+	typ    *FuncType
 }
 
 func (v *funcV) String() string {
@@ -510,7 +601,15 @@ func (v *funcV) Assign(t *Thread, o Value) { v.target = o.(FuncValue).Get(t) }
 
 func (v *funcV) Get(*Thread) Func { return v.target }
 
-func (v *funcV) GetNative(t *Thread) Thing { return v.Get(t) }
+// GetNative returns a native function of the matching native type
+// that calls the interpreted function.  If the function's type is
+// unknown, it returns the Func itself.
+func (v *funcV) GetNative(t *Thread) Thing {
+	if v.target == nil || v.typ == nil || v.typ.builtin != "" {
+		return v.target
+	}
+	return nativeFuncValue(t, v.target, v.typ, nativeTypeOf(v.typ)).Interface()
+}
 
 func (v *funcV) Set(t *Thread, x Func) { v.target = x }
 
@@ -535,18 +634,80 @@ func (v *interfaceV) Assign(t *Thread, o Value) {
 
 func (v *interfaceV) Get(*Thread) Interface { return v.Interface }
 
-func (v *interfaceV) GetNative(t *Thread) Thing { return v.Get(t) }
+func (v *interfaceV) GetNative(t *Thread) Thing {
+	if v.Value == nil {
+		return nil
+	}
+	return v.Value.GetNative(t)
+}
 
 func (v *interfaceV) Set(t *Thread, x Interface) {
 	v.Interface = x
 }
 
+// interfacesEqual reports whether two interface values have identical
+// dynamic types and equal dynamic values.
+func interfacesEqual(t *Thread, a, b Interface) bool {
+	if a.Type == nil || b.Type == nil {
+		return a.Type == nil && b.Type == nil
+	}
+	if a.Type != b.Type {
+		return false
+	}
+	return valuesEqual(t, a.Type, a.Value, b.Value)
+}
+
+// valuesEqual reports whether two values of type typ are equal.  It
+// panics if values of typ are not comparable.
+func valuesEqual(t *Thread, typ Type, a, b Value) bool {
+	switch lt := typ.lit().(type) {
+	case *boolType:
+		return a.(BoolValue).Get(t) == b.(BoolValue).Get(t)
+	case *uintType:
+		return a.(UintValue).Get(t) == b.(UintValue).Get(t)
+	case *intType:
+		return a.(IntValue).Get(t) == b.(IntValue).Get(t)
+	case *floatType:
+		return a.(FloatValue).Get(t) == b.(FloatValue).Get(t)
+	case *stringType:
+		return a.(StringValue).Get(t) == b.(StringValue).Get(t)
+	case *PtrType:
+		return a.(PtrValue).Get(t) == b.(PtrValue).Get(t)
+	case *ChanType:
+		return a.(ChanValue).Get(t) == b.(ChanValue).Get(t)
+	case *InterfaceType:
+		return interfacesEqual(t, a.(InterfaceValue).Get(t), b.(InterfaceValue).Get(t))
+	case *opaqueType:
+		return a.(*opaqueV).rv.Interface() == b.(*opaqueV).rv.Interface()
+	case *ArrayType:
+		av, bv := a.(ArrayValue), b.(ArrayValue)
+		for i := int64(0); i < lt.Len; i++ {
+			if !valuesEqual(t, lt.Elem, av.Elem(t, i), bv.Elem(t, i)) {
+				return false
+			}
+		}
+		return true
+	case *StructType:
+		av, bv := a.(StructValue), b.(StructValue)
+		for i, f := range lt.Elems {
+			if !valuesEqual(t, f.Type, av.Field(t, i), bv.Field(t, i)) {
+				return false
+			}
+		}
+		return true
+	}
+	panic("comparing uncomparable type " + typ.String())
+}
+
 /*
  * Slices
  */
 
 type sliceV struct {
 	Slice
+	// The type of the slice, used to convert it to a native
+	// slice.  nil if unknown.
+	typ *SliceType
 }
 
 func (v *sliceV) String() string {
@@ -560,16 +721,61 @@ func (v *sliceV) Assign(t *Thread, o Value) { v.Slice = o.(SliceValue).Get(t) }
 
 func (v *sliceV) Get(*Thread) Slice { return v.Slice }
 
-func (v *sliceV) GetNative(t *Thread) Thing { return v.Get(t) }
+func (v *sliceV) GetNative(t *Thread) Thing {
+	if v.typ == nil {
+		return v.Get(t)
+	}
+	return nativeSlice(t, v.Slice, nativeTypeOf(v.typ)).Interface()
+}
 
 func (v *sliceV) Set(t *Thread, x Slice) { v.Slice = x }
 
+// newArray returns a new array of n zero elements of type elem, to
+// back a slice.  Arrays of basic values are kept in native memory, so
+// that native code the slice is passed to shares it.
+func newArray(elem Type, n int64) ArrayValue {
+	switch elem.lit().(type) {
+	case *boolType, *uintType, *intType, *floatType, *stringType:
+		rv := reflect.MakeSlice(reflect.SliceOf(nativeTypeOf(elem)), int(n), int(n))
+		return refArrayV{rv, elem}
+	}
+	base := arrayV(make([]Value, n))
+	for i := range base {
+		base[i] = elem.Zero()
+	}
+	return &base
+}
+
+// growSlice returns s extended by n elements.  Like Go's append, it
+// reuses the backing array of s if it has room, and otherwise copies
+// s to a new array, which is native if s's array was.
+func growSlice(t *Thread, s Slice, n int64, elem Type) Slice {
+	sz := s.Len + n
+	if sz <= s.Cap {
+		return Slice{s.Base, sz, s.Cap}
+	}
+	t.alloc(elem, sz)
+	if r, ok := s.Base.(refArrayV); ok {
+		rv := reflect.MakeSlice(reflect.SliceOf(r.rv.Type().Elem()), int(sz), int(sz))
+		reflect.Copy(rv, r.rv.Slice(0, int(s.Len)))
+		return Slice{refArrayV{rv, elem}, sz, sz}
+	}
+	base := newArray(elem, sz)
+	if s.Len > 0 {
+		base.Sub(0, s.Len).Assign(t, s.Base)
+	}
+	return Slice{base, sz, sz}
+}
+
 /*
  * Maps
  */
 
 type mapV struct {
 	target Map
+	// The type of the map, used to convert it to a native map.
+	// nil if unknown.
+	typ *MapType
 }
 
 func (v *mapV) String() string {
@@ -593,7 +799,28 @@ func (v *mapV) Assign(t *Thread, o Value) { v.target = o.(MapValue).Get(t) }
 
 func (v *mapV) Get(*Thread) Map { return v.target }
 
-func (v *mapV) GetNative(t *Thread) Thing { return v.Get(t) }
+func (v *mapV) GetNative(t *Thread) Thing {
+	if v.typ == nil {
+		return v.target
+	}
+	rt := nativeTypeOf(v.typ)
+	if rt.Kind() != reflect.Map {
+		return v.target
+	}
+	if v.target == nil {
+		return reflect.Zero(rt).Interface()
+	}
+	if m, ok := v.target.(nativeMap); ok && m.rv.Type() == rt {
+		return m.rv.Interface()
+	}
+	res := reflect.MakeMapWithSize(rt, int(v.target.Len(t)))
+	v.target.Iter(func(key interface{}, val Value) bool {
+		k := nativeValue(t, mapKeyValue(t, v.typ.Key, key), rt.Key())
+		res.SetMapIndex(k, nativeValue(t, val, rt.Elem()))
+		return true
+	})
+	return res.Interface()
+}
 
 func (v *mapV) Set(t *Thread, x Map) { v.target = x }
 
@@ -621,6 +848,188 @@ func (m evalMap) Iter(cb func(key interface{}, val Value) bool) {
 	}
 }
 
+// valueMapKey converts a Value into a map key, as produced by
+// asInterface.  It is the inverse of mapKeyValue.
+func valueMapKey(t *Thread, v Value) interface{} {
+	switch v := v.(type) {
+	case BoolValue:
+		return v.Get(t)
+	case UintValue:
+		return v.Get(t)
+	case IntValue:
+		return v.Get(t)
+	case FloatValue:
+		return v.Get(t)
+	case StringValue:
+		return v.Get(t)
+	case PtrValue:
+		return v.Get(t)
+	case FuncValue:
+		return v.Get(t)
+	case MapValue:
+		return v.Get(t)
+	case ChanValue:
+		return v.Get(t)
+	case InterfaceValue:
+		return interfaceMapKey(t, v.Get(t))
+	}
+	return v
+}
+
+// An ifaceKey is the map key of an interface value.  The dynamic value
+// is keyed like a value of its dynamic type, so that equal interface
+// values are the same key.
+type ifaceKey struct {
+	typ Type
+	key interface{}
+}
+
+// interfaceMapKey converts an interface value into a map key.
+func interfaceMapKey(t *Thread, i Interface) interface{} {
+	if i.Type == nil {
+		return ifaceKey{}
+	}
+	return ifaceKey{i.Type, valueMapKey(t, i.Value)}
+}
+
+// mapKeyValue converts a map key, as produced by asInterface, back
+// into a Value of the map's key type kt.
+func mapKeyValue(t *Thread, kt Type, key interface{}) Value {
+	v := kt.Zero()
+	switch v := v.(type) {
+	case BoolValue:
+		v.Set(t, key.(bool))
+	case UintValue:
+		v.Set(t, key.(uint64))
+	case IntValue:
+		v.Set(t, key.(int64))
+	case FloatValue:
+		v.Set(t, key.(float64))
+	case StringValue:
+		v.Set(t, key.(string))
+	case PtrValue:
+		v.Set(t, key.(Value))
+	case FuncValue:
+		v.Set(t, key.(Func))
+	case MapValue:
+		v.Set(t, key.(Map))
+	case ChanValue:
+		v.Set(t, key.(Chan))
+	case InterfaceValue:
+		if k := key.(ifaceKey); k.typ != nil {
+			v.Set(t, Interface{k.typ, mapKeyValue(t, k.typ, k.key)})
+		}
+	default:
+		v.Assign(t, key.(Value))
+	}
+	return v
+}
+
+/*
+ * Channels
+ */
+
+type chanV struct {
+	target Chan
+}
+
+func (v *chanV) String() string {
+	if v.target == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%p", v.target)
+}
+
+func (v *chanV) Assign(t *Thread, o Value) { v.target = o.(ChanValue).Get(t) }
+
+func (v *chanV) Get(*Thread) Chan { return v.target }
+
+func (v *chanV) GetNative(t *Thread) Thing { return v.Get(t) }
+
+func (v *chanV) Set(t *Thread, x Chan) { v.target = x }
+
+// evalChan is a channel created by the interpreter.  Values are
+// copied when they are sent, so the receiver never shares storage
+// with the sender.
+type evalChan struct {
+	elem Type
+	c    chan Value
+}
+
+func newEvalChan(elem Type, size int64) *evalChan {
+	return &evalChan{elem, make(chan Value, size)}
+}
+
+func (c *evalChan) Send(t *Thread, v Value) {
+	cp := c.elem.Zero()
+	cp.Assign(t, v)
+	select {
+	case c.c <- cp:
+	case <-t.done():
+		t.Abort(t.limits.ctx.Err())
+	}
+}
+
+func (c *evalChan) Recv(t *Thread) (Value, bool) {
+	var v Value
+	var ok bool
+	select {
+	case v, ok = <-c.c:
+	case <-t.done():
+		t.Abort(t.limits.ctx.Err())
+	}
+	if !ok {
+		return c.elem.Zero(), false
+	}
+	return v, true
+}
+
+func (c *evalChan) Close(t *Thread) { close(c.c) }
+
+func (c *evalChan) Len(*Thread) int64 { return int64(len(c.c)) }
+
+func (c *evalChan) Cap(*Thread) int64 { return int64(cap(c.c)) }
+
+// chanSelectCase returns a case for reflect.Select that sends v on c,
+// or receives from c if v is nil.  A nil channel produces a case that
+// is never ready.  Only channels created by the interpreter can be
+// selected on.
+func chanSelectCase(t *Thread, c Chan, v Value) reflect.SelectCase {
+	sc := reflect.SelectCase{Dir: reflect.SelectRecv}
+	if v != nil {
+		sc.Dir = reflect.SelectSend
+		sc.Send = reflect.ValueOf(v)
+	}
+	switch c := c.(type) {
+	case nil:
+	case *evalChan:
+		sc.Chan = reflect.ValueOf(c.c)
+	default:
+		t.Abort(&InternalError{Message: fmt.Sprintf("cannot select on channel %T", c)})
+	}
+	return sc
+}
+
+/*
+ * Range iterators
+ */
+
+// A rangeV holds the state of a single execution of a for range
+// statement.  It lives in a temporary frame slot so that recursive
+// invocations each get their own iterator.
+type rangeV struct {
+	// next advances the iterator, setting key and val, and
+	// returns false once the range is exhausted.
+	next     func(t *Thread) bool
+	key, val Value
+}
+
+func (v *rangeV) String() string { return "<range>" }
+
+func (v *rangeV) Assign(t *Thread, o Value) { *v = *o.(*rangeV) }
+
+func (v *rangeV) GetNative(t *Thread) Thing { return v }
+
 /*
  * package value
  */
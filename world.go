@@ -9,6 +9,7 @@ package chicklet
 
 import (
 	"bitbucket.org/binet/go-types/pkg/types"
+	"context"
 	"errors"
 	"reflect"
 	"fmt"
@@ -25,6 +26,76 @@ var g_visiting = make(map[string]status)
 
 type Spec struct {
 	ImportsAllowed bool
+	// If DefaultConstants is true, untyped constant results of
+	// Eval are converted to int or float64, as if assigned to a
+	// variable, instead of being returned as *big.Int or
+	// *big.Rat.
+	DefaultConstants bool
+	// If MaxSteps is positive, each run of compiled code, and of
+	// functions returned by Eval, aborts with a BudgetError once
+	// it has executed MaxSteps instructions.  The goroutines a
+	// run starts count against its budget.
+	MaxSteps int64
+	// If MaxMemory is positive, a run aborts with a MemoryError
+	// once the values allocated by all runs in the World would
+	// take more than MaxMemory bytes in total.  Memory is
+	// accounted approximately, by the size of the values in
+	// compiled Go, and is not given back when values become
+	// garbage, so a World that keeps running code eventually
+	// exhausts it.
+	MaxMemory int64
+	// If MaxCallDepth is positive, a run aborts with a
+	// StackOverflowError when its interpreted calls nest deeper
+	// than MaxCallDepth.  Otherwise DefaultMaxCallDepth applies.
+	MaxCallDepth int
+	// Policy restricts the code compiled in the World.
+	Policy Policy
+}
+
+// A Policy restricts what scripts may do.  It is enforced when code
+// is compiled, so changing it does not affect code compiled before.
+// The zero Policy allows everything.
+type Policy struct {
+	// If AllowImports is not nil, only the packages with the
+	// import paths it lists may be imported.
+	AllowImports []string
+	// The packages with the import paths DenyImports lists may
+	// not be imported.
+	DenyImports []string
+	// The built-in functions DenyBuiltins lists, such as "print"
+	// or "panic", may not be used.
+	DenyBuiltins []string
+	// If NoGoroutines is true, go statements are not allowed.
+	NoGoroutines bool
+	// If NoUnboundedLoops is true, for statements must have a
+	// condition that is not constant true, or a range clause over
+	// anything but a channel, and goto statements may only jump
+	// forward.  Other conditions may still never become false;
+	// Spec.MaxSteps bounds those loops, and Spec.MaxCallDepth
+	// bounds recursion.
+	NoUnboundedLoops bool
+}
+
+// importError returns the error that importing path causes under s,
+// or nil if the import is allowed.
+func (s *Spec) importError(path string) error {
+	if !s.ImportsAllowed {
+		return &CompileError{"Imports are not allowed"}
+	}
+	p := &s.Policy
+	if (p.AllowImports != nil && !contains(p.AllowImports, path)) || contains(p.DenyImports, path) {
+		return &CompileError{fmt.Sprintf("import of %q is not allowed", path)}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
 }
 
 type status int // status for visiting map
@@ -38,6 +109,9 @@ type Thing interface{}
 
 type Executable interface {
 	Execute(things... Thing) ([]Thing, error)
+	// ExecuteContext is like Execute, but aborts with ctx.Err()
+	// once ctx is done.
+	ExecuteContext(ctx context.Context, things... Thing) ([]Thing, error)
 }
 
 
@@ -55,6 +129,21 @@ func (self *CompileError) Error() string {
 	return self.Message
 }
 
+// An InternalError reports a failure of the interpreter itself, such
+// as an unsupported construct that was not diagnosed, rather than an
+// error in the compiled code.
+type InternalError struct {
+	Pos     token.Position
+	Message string
+}
+
+func (self *InternalError) Error() string {
+	if self.Pos.IsValid() {
+		return fmt.Sprintf("%v: internal error: %s", self.Pos, self.Message)
+	}
+	return "internal error: " + self.Message
+}
+
 type CallError struct {
 	Message string
 }
@@ -67,10 +156,16 @@ type World struct {
 	frame *Frame
 	inits []Code
 	spec *Spec
+	// The bytes allocated by code run in the World, accounted
+	// against Spec.MaxMemory.
+	memory *int64
+	// The call depth of the native call in progress, shared by
+	// the World's threads.
+	nativeDepth *int64
 }
 
 func NewWorld() *World {
-	w := &World{spec: &Spec{true}}
+	w := &World{spec: &Spec{ImportsAllowed: true}, memory: new(int64), nativeDepth: new(int64)}
 	w.scope = universe.ChildScope()
 	w.scope.global = true // this block's vars allocate directly
 	return w
@@ -83,6 +178,10 @@ type Code interface {
 	// Run runs the code; if the code is a single expression
 	// with a value, it returns the value; otherwise it returns nil.
 	Run() (Value, error)
+
+	// RunContext is like Run, but aborts with ctx.Err() once ctx
+	// is done.
+	RunContext(ctx context.Context) (Value, error)
 }
 
 type pkgCode struct {
@@ -92,8 +191,10 @@ type pkgCode struct {
 
 func (p *pkgCode) Type() Type { return nil }
 
-func (p *pkgCode) Run() (Value, error) {
-	t := new(Thread)
+func (p *pkgCode) Run() (Value, error) { return p.RunContext(context.Background()) }
+
+func (p *pkgCode) RunContext(ctx context.Context) (Value, error) {
+	t := p.w.newThread(ctx)
 	t.f = p.w.scope.NewFrame(nil)
 	return nil, t.Try(func(t *Thread) { p.code.exec(t) })
 }
@@ -102,6 +203,12 @@ func (w *World) Spec() *Spec {
 	return w.spec
 }
 
+// newThread returns a thread for a run of code compiled in w, under
+// the limits of its Spec, that is cancelled by ctx.
+func (w *World) newThread(ctx context.Context) *Thread {
+	return &Thread{limits: newLimits(ctx, w.spec, w.memory), maxDepth: w.spec.MaxCallDepth, nativeDepth: w.nativeDepth}
+}
+
 func (w *World) CompilePackage(fset *token.FileSet, files []*ast.File, pkgpath string) (Code, error) {
 	pkgFiles := make(map[string]*ast.File)
 	for _, f := range files {
@@ -131,10 +238,16 @@ func (w *World) CompilePackage(fset *token.FileSet, files []*ast.File, pkgpath s
 
 	for _, imp := range imports {
 		path, _ := strconv.Unquote(imp.Path.Value)
-		if _, ok := universe.pkgs[path]; ok {
+		if err := w.spec.importError(path); err != nil {
+			return nil, err
+		}
+		if _, ok := universe.pkg(path); ok {
 			// already compiled
 			continue
 		}
+		if importNative(path) {
+			continue
+		}
 		imp_files, err := findPkgFiles(path)
 		if err != nil {
 			return nil, errors.New(fmt.Sprintf("could not find files for package [%s]", path))
@@ -155,7 +268,9 @@ func (w *World) CompilePackage(fset *token.FileSet, files []*ast.File, pkgpath s
 	defer func() {
 		g_visiting[pkgpath] = done
 		// add this scope (the package's scope) to the lookup-table of packages
+		universe.mu.Lock()
 		universe.pkgs[pkgpath] = w.scope
+		universe.mu.Unlock()
 		// restore the previous scope
 		w.scope.exit()
 		if pkgpath != "main" {
@@ -195,14 +310,21 @@ type stmtCode struct {
 	code code
 }
 
-func (w *World) CompileStmtList(fset *token.FileSet, stmts []ast.Stmt) (Code, error) {
+func (w *World) CompileStmtList(fset *token.FileSet, stmts []ast.Stmt) (_ Code, err error) {
 	if len(stmts) == 1 {
 		if s, ok := stmts[0].(*ast.ExprStmt); ok {
 			return w.CompileExpr(fset, s.X)
 		}
 	}
 	errors := new(scanner.ErrorList)
-	cc := &compiler{fset, errors, 0, 0}
+	cc := &compiler{fset, errors, 0, 0, token.NoPos, w.spec}
+	defer func() {
+		if _, ok := err.(*InternalError); ok {
+			// Forget any blocks left entered by the failure.
+			w.scope.inner = nil
+		}
+	}()
+	defer cc.recoverInternal(&err)
 	cb := newCodeBuf()
 	fc := &funcCompiler{
 		compiler:     cc,
@@ -238,8 +360,10 @@ func (w *World) CompileDeclList(fset *token.FileSet, decls []ast.Decl) (Code, er
 
 func (s *stmtCode) Type() Type { return nil }
 
-func (s *stmtCode) Run() (Value, error) {
-	t := new(Thread)
+func (s *stmtCode) Run() (Value, error) { return s.RunContext(context.Background()) }
+
+func (s *stmtCode) RunContext(ctx context.Context) (Value, error) {
+	t := s.w.newThread(ctx)
 	t.f = s.w.scope.NewFrame(nil)
 	return nil, t.Try(func(t *Thread) { s.code.exec(t) })
 }
@@ -250,9 +374,16 @@ type exprCode struct {
 	eval func(Value, *Thread)
 }
 
-func (w *World) CompileExpr(fset *token.FileSet, e ast.Expr) (Code, error) {
+func (w *World) CompileExpr(fset *token.FileSet, e ast.Expr) (_ Code, err error) {
 	errors := new(scanner.ErrorList)
-	cc := &compiler{fset, errors, 0, 0}
+	cc := &compiler{fset, errors, 0, 0, token.NoPos, w.spec}
+	defer func() {
+		if _, ok := err.(*InternalError); ok {
+			// Forget any blocks left entered by the failure.
+			w.scope.inner = nil
+		}
+	}()
+	defer cc.recoverInternal(&err)
 
 	ec := cc.compileExpr(w.scope.block, false, e)
 	if ec == nil {
@@ -276,8 +407,10 @@ func (w *World) CompileExpr(fset *token.FileSet, e ast.Expr) (Code, error) {
 
 func (e *exprCode) Type() Type { return e.e.t }
 
-func (e *exprCode) Run() (Value, error) {
-	t := new(Thread)
+func (e *exprCode) Run() (Value, error) { return e.RunContext(context.Background()) }
+
+func (e *exprCode) RunContext(ctx context.Context) (Value, error) {
+	t := e.w.newThread(ctx)
 	t.f = e.w.scope.NewFrame(nil)
 	switch e.e.t.(type) {
 	case *idealIntType:
@@ -311,7 +444,20 @@ func (self *World) Comp(s string) (Code, error) {
 	return self.Compile(defaultFileSet, s)
 }
 
-func (w *World) Compile(fset *token.FileSet, text string) (Code, error) {
+func (w *World) Compile(fset *token.FileSet, text string) (_ Code, err error) {
+	// Errors in the compiler itself are normally caught where the
+	// source position is known; this catches the rest, and reports
+	// them at the node being compiled.
+	var node ast.Node
+	defer func() {
+		if r := recover(); r != nil {
+			var pos token.Position
+			if node != nil {
+				pos = fset.Position(node.Pos())
+			}
+			err = &InternalError{pos, fmt.Sprint(r)}
+		}
+	}()
 	if text == "main()" {
 		err := w.run_init()
 		if err != nil {
@@ -319,22 +465,24 @@ func (w *World) Compile(fset *token.FileSet, text string) (Code, error) {
 		}
 	}
 	if i := import_regexp.FindStringIndex(text); i != nil && i[0] == 0 {
-		if w.Spec().ImportsAllowed {
-			// special case for import-ing on the command line...
-			return w.compileImport(fset, text)
-		} else {
-			return nil, &CompileError{"Imports are not allowed"}
-		}
+		// special case for import-ing on the command line...
+		return w.compileImport(fset, text, &node)
 	}
 
 	stmts, err := parseStmtList(fset, text)
 	if err == nil {
+		if len(stmts) > 0 {
+			node = stmts[0]
+		}
 		return w.CompileStmtList(fset, stmts)
 	}
 
 	// Otherwise try as DeclList
 	decls, err1 := parseDeclList(fset, text)
 	if err1 == nil {
+		if len(decls) > 0 {
+			node = decls[0]
+		}
 		return w.CompileDeclList(fset, decls)
 	}
 
@@ -346,33 +494,85 @@ func (w *World) Compile(fset *token.FileSet, text string) (Code, error) {
 
 var defaultFileSet = token.NewFileSet()
 
-func (self *World) Define(name string, thing Thing) {
-	self.DefineVar(name, TypeFromNative(reflect.TypeOf(thing)), ValueFromNative(thing, &Thread{}))
+// Define defines a variable called name holding the native value
+// thing.  If thing cannot be represented in the interpreter, it
+// returns a ConvertError.
+func (self *World) Define(name string, thing Thing) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if ce, ok := r.(*ConvertError); ok {
+				err = ce
+			} else {
+				err = &InternalError{token.Position{}, fmt.Sprint(r)}
+			}
+		}
+	}()
+	return self.DefineVar(name, TypeFromNative(reflect.TypeOf(thing)), ValueFromNative(thing, &Thread{}))
 }
 
 func (self *World) Eval(s string) (Thing, error) {
+	return self.EvalContext(context.Background(), s)
+}
+
+// EvalContext is like Eval, but aborts with ctx.Err() once ctx is
+// done.
+func (self *World) EvalContext(ctx context.Context, s string) (Thing, error) {
 	code, err := self.Comp(s)
 	if err != nil {
 		return nil, err
 	}
-	value, err := code.Run()
+	value, err := code.RunContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 	if value == nil {
 		return nil, nil
 	}
-	return value.GetNative(&Thread{}), nil
+	if self.spec.DefaultConstants {
+		switch v := value.(type) {
+		case *idealIntV:
+			if !v.V.IsInt64() || int64(int(v.V.Int64())) != v.V.Int64() {
+				return nil, &ConvertError{fmt.Sprintf("constant %v overflows int", v.V)}
+			}
+			return int(v.V.Int64()), nil
+		case *idealFloatV:
+			f, _ := v.V.Float64()
+			return f, nil
+		}
+	}
+	// Function results are returned as Executables, which report
+	// script panics as errors instead of panicking.
+	if fv, ok := value.(FuncValue); ok {
+		if f := fv.Get(nil); f != nil {
+			if e, ok := f.(executor); ok {
+				return &worldFunc{self, e}, nil
+			}
+			return f, nil
+		}
+	}
+	return nativeThing(&Thread{}, value, code.Type()), nil
 }
 
-func (w *World) compileImport(fset *token.FileSet, text string) (Code, error) {
+// compileImport compiles the import declarations in text.  It records
+// the import being compiled in node, for reporting internal errors.
+func (w *World) compileImport(fset *token.FileSet, text string, node *ast.Node) (Code, error) {
 	f, err := parser.ParseFile(fset, "input", "package main;"+text, 0)
 	if err != nil {
 		return nil, err
 	}
 
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if err := w.spec.importError(path); err != nil {
+			return nil, err
+		}
+	}
 	imp := f.Imports[0]
+	*node = imp
 	path, _ := strconv.Unquote(imp.Path.Value)
+	if importNative(path) {
+		return w.CompileDeclList(fset, f.Decls)
+	}
 	imp_files, err := findPkgFiles(path)
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("could not find files for package [%s]", path))
@@ -416,15 +616,9 @@ type RedefinitionError struct {
 }
 
 func (e *RedefinitionError) Error() string {
-	res := "identifier " + e.Name + " redeclared"
-	pos := e.Prev.Pos()
-	if pos.IsValid() {
-		// TODO: fix this - currently this code is not reached by the tests
-		//       need to get a file set (fset) from somewhere
-		//res += "; previous declaration at " + fset.Position(pos).String()
-		panic(0)
-	}
-	return res
+	// TODO: report the previous declaration's position; this
+	// needs the file set it was compiled with.
+	return "identifier " + e.Name + " redeclared"
 }
 
 func (w *World) DefineConst(name string, t Type, val Value) error {